@@ -4,5 +4,13 @@ go 1.16
 
 require (
 	github.com/fsnotify/fsnotify v1.4.9
+	github.com/gorilla/websocket v1.5.0
+	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+	go.uber.org/goleak v1.1.10
 	go.uber.org/zap v1.15.0
+	golang.org/x/time v0.1.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -2,35 +2,106 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/evsamsonov/jsonrpc-fsproxy/pkg/jsonrpc"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func main() {
-	if len(os.Args) < 4 {
-		fmt.Println("Usage: jsonrpc-fsproxy [INPUT_FILE_PATH] [OUTPUT_FILE_PATH] [RPC_URL]")
-		os.Exit(1)
+	configPath := flag.String("config", "", "Path of YAML or JSON config file. "+
+		"Flags and arguments which are set override it")
+	logLevel := flag.String("log-level", "debug", "Log level: debug, info, warn or error")
+	requestTimeout := flag.Duration("timeout", 30*time.Second, "Timeout of a single RPC request, 0 disables it")
+	maxConcurrency := flag.Int("max-concurrency", 100, "Maximum number of simultaneous RPC requests, 0 means no limit")
+	pollInterval := flag.Duration("poll-interval", 0,
+		"Check the input file for changes with the interval instead of filesystem notifications, 0 disables polling")
+	truncateOutput := flag.Bool("truncate-output", false, "Empty the output file on start instead of appending to it")
+	healthAddr := flag.String("health-addr", "",
+		"Address of /healthz and /readyz endpoints, e.g. :8080. Empty disables them")
+	urlStrategy := flag.String("url-strategy", "round-robin",
+		"How RPC URL is chosen if several are set: round-robin or failover")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second,
+		"How long to wait for the proxy to stop on SIGINT or SIGTERM before closing it forcibly, 0 means no limit")
+	dryRun := flag.Bool("dry-run", false, "Log requests instead of sending them and write {\"dryRun\":true} responses")
+	stdin := flag.Bool("stdin", false, "Read requests from stdin until EOF instead of the input file, requires -stdout")
+	stdout := flag.Bool("stdout", false, "Write responses to stdout instead of the output file, requires -stdin")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintln(out, "Usage: jsonrpc-fsproxy [FLAGS] [INPUT_FILE_PATH] [OUTPUT_FILE_PATH] [RPC_URL...]")
+		fmt.Fprintln(out, "       jsonrpc-fsproxy -stdin -stdout [FLAGS] [RPC_URL...]")
+		fmt.Fprintln(out, "Arguments can be omitted if they are set in the config file")
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	inputFilePath, outputFilePath, rpcURL := os.Args[1], os.Args[2], os.Args[3]
+	if *stdin != *stdout {
+		log.Fatal("Flags -stdin and -stdout must be set together")
+	}
+	validArgs := flag.NArg() >= 3 || flag.NArg() == 0 && *configPath != ""
+	if *stdin {
+		validArgs = flag.NArg() > 0 || *configPath != ""
+	}
+	if !validArgs {
+		flag.Usage()
+		os.Exit(1)
+	}
+	setFlags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	flagConfig := jsonrpc.Config{
+		RequestTimeout: requestTimeout,
+		MaxConcurrency: maxConcurrency,
+		URLStrategy:    *urlStrategy,
+	}
+	load := func() (jsonrpc.Config, error) {
+		return loadConfig(*configPath, *stdin, flagConfig, setFlags)
+	}
+	config, err := load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
 
-	logger, err := zap.NewDevelopment()
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		log.Fatalf("Invalid log level: %v", err)
+	}
+	loggerConfig := zap.NewDevelopmentConfig()
+	loggerConfig.Level = zap.NewAtomicLevelAt(level)
+	logger, err := loggerConfig.Build()
 	if err != nil {
 		log.Fatalf("Failed to create logger: %v", err)
 	}
-	proxy, err := jsonrpc.NewFSProxy(
-		rpcURL,
-		inputFilePath,
-		outputFilePath,
-		logger,
-	)
+	var opts []jsonrpc.Option
+	if *truncateOutput {
+		opts = append(opts, jsonrpc.WithTruncateOutput())
+	}
+	if *dryRun {
+		opts = append(opts, jsonrpc.WithDryRun())
+	}
+	if *pollInterval > 0 {
+		opts = append(opts, jsonrpc.WithPolling(*pollInterval))
+	}
+	if len(config.RPCURLs) > 0 && isWebSocketURL(config.RPCURLs[0]) {
+		sender := jsonrpc.NewWebSocketSender(config.RPCURLs[0], nil, nil)
+		defer func() {
+			if err := sender.Close(); err != nil {
+				logger.Warn("Failed to close websocket sender", zap.Error(err))
+			}
+		}()
+		opts = append(opts, jsonrpc.WithSender(sender))
+	}
+	proxy, err := newProxy(config, *stdin, jsonrpc.NewZapLogger(logger), opts)
 	if err != nil {
 		logger.Fatal("Failed to create proxy", zap.Error(err))
 	}
@@ -40,6 +111,10 @@ func main() {
 		}
 	}()
 
+	if *healthAddr != "" {
+		defer serveHealth(*healthAddr, proxy.HealthHandler(), logger)()
+	}
+
 	var wg sync.WaitGroup
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -51,12 +126,132 @@ func main() {
 			logger.Fatal("Failed to run proxy", zap.Error(err))
 		}
 	}()
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-	sig := make(chan os.Signal)
+	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	for stop := false; !stop; {
+		select {
+		case <-done:
+			return
+		case <-reload:
+			reloadConfig(proxy, load, logger)
+		case <-sig:
+			cancel()
+			stop = true
+		}
+	}
+	waitShutdown(done, *shutdownTimeout, proxy, logger)
+}
+
+// loadConfig reads the config file at configPath, if any, and overrides it with arguments
+// and flags which are set. Values of flagConfig are the ones of flags
+func loadConfig(
+	configPath string,
+	stdin bool,
+	flagConfig jsonrpc.Config,
+	setFlags map[string]bool,
+) (jsonrpc.Config, error) {
+	var config jsonrpc.Config
+	if configPath != "" {
+		var err error
+		if config, err = jsonrpc.LoadConfig(configPath); err != nil {
+			return jsonrpc.Config{}, err
+		}
+	}
+	switch {
+	case stdin && flag.NArg() > 0:
+		config.RPCURLs = flag.Args()
+	case !stdin && flag.NArg() >= 3:
+		config.InputFile, config.OutputFile, config.RPCURLs = flag.Arg(0), flag.Arg(1), flag.Args()[2:]
+	}
+	// Without config file defaults of flags apply as well
+	if configPath == "" || setFlags["timeout"] {
+		config.RequestTimeout = flagConfig.RequestTimeout
+	}
+	if configPath == "" || setFlags["max-concurrency"] {
+		config.MaxConcurrency = flagConfig.MaxConcurrency
+	}
+	if configPath == "" || setFlags["url-strategy"] {
+		config.URLStrategy = flagConfig.URLStrategy
+	}
+	return config, nil
+}
+
+// serveHealth serves health endpoints of handler at addr until the returned stop is called
+func serveHealth(addr string, handler http.Handler, logger *zap.Logger) (stop func()) {
+	server := &http.Server{Addr: addr, Handler: handler}
 	go func() {
-		<-sig
-		cancel()
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("Failed to serve health endpoints", zap.Error(err))
+		}
 	}()
-	wg.Wait()
+	return func() {
+		if err := server.Close(); err != nil {
+			logger.Warn("Failed to close health server", zap.Error(err))
+		}
+	}
+}
+
+// waitShutdown waits for the proxy to stop after it is cancelled, that is for done to be closed.
+// If it does not stop within timeout, proxy is closed and the process exits. Zero timeout means no limit
+func waitShutdown(done <-chan struct{}, timeout time.Duration, proxy *jsonrpc.FSProxy, logger *zap.Logger) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+	select {
+	case <-done:
+	case <-timer:
+		// E.g. a request or a write hangs, so files are closed without waiting for it
+		logger.Warn("Proxy has not stopped within shutdown timeout, closing it", zap.Duration("timeout", timeout))
+		if err := proxy.Close(); err != nil {
+			logger.Warn("Failed to close proxy", zap.Error(err))
+		}
+		os.Exit(1)
+	}
+}
+
+// isWebSocketURL reports whether requests to rpcURL are sent over WebSocket
+func isWebSocketURL(rpcURL string) bool {
+	return strings.HasPrefix(rpcURL, "ws://") || strings.HasPrefix(rpcURL, "wss://")
+}
+
+// newProxy creates proxy configured with config, which uses stdin and stdout
+// instead of the files if stdio is set
+func newProxy(
+	config jsonrpc.Config,
+	stdio bool,
+	logger jsonrpc.Logger,
+	opts []jsonrpc.Option,
+) (*jsonrpc.FSProxy, error) {
+	if !stdio {
+		return jsonrpc.NewFromConfig(config, logger, opts...)
+	}
+	if len(config.RPCURLs) == 0 {
+		return nil, errors.New("no RPC URL")
+	}
+	configOpts, err := config.Options()
+	if err != nil {
+		return nil, err
+	}
+	return jsonrpc.NewStreamProxy(config.RPCURLs[0], os.Stdin, os.Stdout, logger, append(configOpts, opts...)...)
+}
+
+// reloadConfig applies the config returned by load to proxy
+func reloadConfig(proxy *jsonrpc.FSProxy, load func() (jsonrpc.Config, error), logger *zap.Logger) {
+	config, err := load()
+	if err != nil {
+		logger.Error("Failed to reload config", zap.Error(err))
+		return
+	}
+	if err := proxy.Reload(config); err != nil {
+		logger.Error("Failed to reload config", zap.Error(err))
+	}
 }
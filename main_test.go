@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so the CLI is tested
+// without building it separately
+const runMainEnv = "JSONRPC_FSPROXY_RUN_MAIN"
+
+// waitTimeout bounds waiting for the CLI
+const waitTimeout = 10 * time.Second
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// command returns command running the CLI with args
+func command(args ...string) *exec.Cmd {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	return cmd
+}
+
+// echoServer starts a JSON-RPC server replying with a result equal to the method of the request
+func echoServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil || json.Unmarshal(body, &request) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(request.ID) + `,"result":"` + request.Method + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStdinStdout(t *testing.T) {
+	server := echoServer(t)
+	fixture := `{"jsonrpc":"2.0","id":1,"method":"first"}
+{"jsonrpc":"2.0","id":2,"method":"second"}
+{"jsonrpc":"2.0","id":3,"method":"third"}
+`
+	cmd := command("-stdin", "-stdout", "-log-level", "error", server.URL)
+	cmd.Stdin = strings.NewReader(fixture)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// EOF of stdin makes the CLI exit once the requests are proxied
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("run: %v, stderr: %s", err, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
+	sort.Strings(lines)
+	want := []string{
+		`{"jsonrpc":"2.0","id":1,"result":"first"}`,
+		`{"jsonrpc":"2.0","id":2,"result":"second"}`,
+		`{"jsonrpc":"2.0","id":3,"result":"third"}`,
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("stdout = %q, want %q", lines, want)
+	}
+}
+
+func TestStdinWithoutStdout(t *testing.T) {
+	cmd := command("-stdin", "http://localhost")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() == 0 {
+		t.Fatalf("run error = %v, want non-zero exit code", err)
+	}
+	if !strings.Contains(stderr.String(), "-stdin and -stdout must be set together") {
+		t.Errorf("stderr = %q, want flags error", stderr.String())
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupt signal can not be sent on windows")
+	}
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = ioutil.ReadAll(r.Body)
+		select {
+		case received <- struct{}{}:
+		default:
+		}
+		// The backend hangs until the client goes away
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "input")
+	configPath := filepath.Join(dir, "config.yaml")
+	// In-flight requests are drained much longer than the shutdown timeout
+	if err := ioutil.WriteFile(configPath, []byte("drainTimeout: 1h\n"), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cmd := command("-config", configPath, "-shutdown-timeout", "200ms", "-log-level", "warn",
+		inputPath, filepath.Join(dir, "output"), server.URL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	defer func() {
+		_ = cmd.Process.Kill()
+	}()
+
+	// Lines written before the proxy starts are skipped, so they are written until one is sent
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(waitTimeout)
+	for sent := false; !sent; {
+		select {
+		case <-received:
+			sent = true
+		case <-ticker.C:
+			appendLine(t, inputPath, `{"jsonrpc":"2.0","id":1,"method":"hang"}`)
+		case err := <-exited:
+			t.Fatalf("exited before the request: %v, stderr: %s", err, stderr.String())
+		case <-timeout:
+			t.Fatal("timed out waiting for the request")
+		}
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatalf("interrupt: %v", err)
+	}
+
+	select {
+	case err := <-exited:
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+			t.Errorf("exit error = %v, want exit code 1", err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("process has not exited after shutdown timeout")
+	}
+	if !strings.Contains(stderr.String(), "Proxy has not stopped within shutdown timeout") {
+		t.Errorf("stderr = %q, want shutdown timeout warning", stderr.String())
+	}
+}
+
+// appendLine appends line to the file at path creating it if needed
+func appendLine(t *testing.T, path, line string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,31 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// isBatch reports whether payload is a JSON-RPC batch, i.e. a JSON array
+func isBatch(payload []byte) bool {
+	trimmed := bytes.TrimLeft(payload, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// splitBatchResponse converts batch response to separate messages, one per response object.
+// It returns nil if batch response is empty
+func splitBatchResponse(response []byte) ([][]byte, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(response, &items); err != nil {
+		return nil, fmt.Errorf("unmarshal batch response: %w", err)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	messages := make([][]byte, 0, len(items))
+	for _, item := range items {
+		messages = append(messages, item)
+	}
+	return messages, nil
+}
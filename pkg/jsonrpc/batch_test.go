@@ -0,0 +1,30 @@
+package jsonrpc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFSProxyBatch(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	batch := "[" + rpcRequest(1, "first") + "," + rpcRequest(2, "second") + "]"
+
+	t.Run("as is", func(t *testing.T) {
+		p := newTestProxy(t, server.URL).start()
+		p.write(batch)
+
+		want := "[" + rpcResult(1, "first") + "," + rpcResult(2, "second") + "]"
+		if lines := p.waitLines(1); lines[0] != want {
+			t.Errorf("output = %q, want %q", lines[0], want)
+		}
+	})
+	t.Run("split", func(t *testing.T) {
+		p := newTestProxy(t, server.URL, WithSplitBatchResponses()).start()
+		p.write(batch)
+
+		want := []string{rpcResult(1, "first"), rpcResult(2, "second")}
+		if lines := p.waitLines(2); !reflect.DeepEqual(lines, want) {
+			t.Errorf("output = %q, want %q", lines, want)
+		}
+	})
+}
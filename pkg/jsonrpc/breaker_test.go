@@ -0,0 +1,69 @@
+package jsonrpc
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFSProxyCircuitBreaker(t *testing.T) {
+	var healthy int32
+	var requests int32
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		echoHandler(w, r)
+	})
+	const cooldown = 100 * time.Millisecond
+	p := newTestProxy(t, server.URL, WithCircuitBreaker(2, cooldown), WithMaxConcurrency(1)).start()
+
+	p.write(rpcRequest(1, "a"), rpcRequest(2, "b"), rpcRequest(3, "c"))
+	for i := 0; i < 3; i++ {
+		lineErr := p.waitLineError()
+		if i == 2 && !errors.Is(lineErr, ErrCircuitOpen) {
+			t.Errorf("error of the request after failures = %v, want %v", lineErr, ErrCircuitOpen)
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Errorf("requests sent = %d, want 2", got)
+	}
+
+	// The probe request after cooldown closes the breaker
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(cooldown)
+	p.write(rpcRequest(4, "d"), rpcRequest(5, "e"))
+	if lines := p.waitLines(2); lines[1] != rpcResult(5, "e") {
+		t.Errorf("output = %q, want responses after recovery", lines)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, time.Hour)
+
+	b.record(true)
+	if !b.allow() {
+		t.Fatal("breaker is open after a single failure")
+	}
+	b.record(true)
+	if b.allow() {
+		t.Fatal("breaker is closed after threshold failures")
+	}
+
+	// Probe request is let through once cooldown passes
+	b.openedAt = time.Now().Add(-2 * time.Hour)
+	if !b.allow() {
+		t.Fatal("probe request is rejected after cooldown")
+	}
+	if b.allow() {
+		t.Fatal("request is allowed while the probe is in flight")
+	}
+	b.record(false)
+	if !b.allow() {
+		t.Error("breaker is open after the successful probe")
+	}
+}
@@ -0,0 +1,98 @@
+package jsonrpc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// checkpoint persists the offset of the input file up to which all lines are processed,
+// so the proxy resumes from it after restart
+type checkpoint struct {
+	path    string
+	perm    os.FileMode
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]int64
+	offset  int64
+	dirty   bool
+}
+
+func newCheckpoint(path string, perm os.FileMode) *checkpoint {
+	return &checkpoint{
+		path:    path,
+		perm:    perm,
+		pending: make(map[uint64]int64),
+	}
+}
+
+// load returns the saved offset. It returns false if nothing is saved yet
+func (c *checkpoint) load() (int64, bool, error) {
+	data, err := ioutil.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("read checkpoint file: %w", err)
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parse checkpoint: %w", err)
+	}
+	return offset, true, nil
+}
+
+// done marks the line with sequence number seq as processed. Offset is the position
+// of the input file after the line, negative if the line is not from the input file
+func (c *checkpoint) done(seq uint64, offset int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pending[seq] = offset
+	for {
+		offset, ok := c.pending[c.next]
+		if !ok {
+			return
+		}
+		delete(c.pending, c.next)
+		c.next++
+		if offset >= 0 {
+			c.offset = offset
+			c.dirty = true
+		}
+	}
+}
+
+// save writes the offset to the checkpoint file if it changed since the last save
+func (c *checkpoint) save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	offset := c.offset
+	c.dirty = false
+	c.mu.Unlock()
+
+	// Write to a temporary file first so the checkpoint is never left half-written
+	tmpPath := c.path + ".tmp"
+	file, err := openFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, c.perm)
+	if err != nil {
+		return fmt.Errorf("open checkpoint file: %w", err)
+	}
+	if _, err := file.WriteString(strconv.FormatInt(offset, 10)); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("write checkpoint file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close checkpoint file: %w", err)
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		return fmt.Errorf("rename checkpoint file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package jsonrpc
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFSProxyCheckpointRestart(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint")
+	first := newTestProxy(t, server.URL, WithCheckpoint(checkpointPath, 10*time.Millisecond)).start()
+	first.write(rpcRequest(1, "first"), rpcRequest(2, "second"))
+	first.waitLines(2)
+	if err := first.stop(); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if err := first.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	second := newTestProxyAt(t, server.URL, first.inputPath, first.outputPath,
+		WithReadExisting(), WithCheckpoint(checkpointPath, 10*time.Millisecond))
+	second.write(rpcRequest(3, "after-restart"))
+	second.start()
+	second.waitLines(3)
+
+	// Lines proxied before restart are not sent again
+	want := []string{rpcRequest(1, "first"), rpcRequest(2, "second"), rpcRequest(3, "after-restart")}
+	requests := recorder.received()
+	sort.Strings(requests)
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+}
+
+func TestCheckpointSavesContiguousOffset(t *testing.T) {
+	c := newCheckpoint(filepath.Join(t.TempDir(), "checkpoint"), defaultFilePerm)
+
+	c.done(1, 20)
+	if err := c.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, ok, err := c.load(); err != nil || ok {
+		t.Errorf("load before the first line is done = %v, %v, want nothing saved", ok, err)
+	}
+	c.done(0, 10)
+	if err := c.save(); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if offset, ok, err := c.load(); err != nil || !ok || offset != 20 {
+		t.Errorf("load = %d, %v, %v, want 20", offset, ok, err)
+	}
+}
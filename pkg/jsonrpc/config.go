@@ -0,0 +1,114 @@
+package jsonrpc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the configuration of FSProxy read from a YAML or JSON file by LoadConfig.
+// Unset fields keep the defaults of NewFSProxy
+type Config struct {
+	// RPCURLs are URLs of the JSON-RPC server. Requests are sent to the first one
+	// unless there are several
+	RPCURLs []string `yaml:"rpcURLs"`
+	// URLStrategy is how a URL is chosen if there are several: "round-robin" or "failover"
+	URLStrategy string `yaml:"urlStrategy"`
+	// InputFile is the path of the input file
+	InputFile string `yaml:"inputFile"`
+	// ExtraInputFiles are paths of input files watched along with InputFile
+	ExtraInputFiles []string `yaml:"extraInputFiles"`
+	// OutputFile is the path of the output file
+	OutputFile string `yaml:"outputFile"`
+	// RequestTimeout is the timeout of a single RPC request, e.g. "30s"
+	RequestTimeout *time.Duration `yaml:"requestTimeout"`
+	// DrainTimeout is how long in-flight requests are waited for on shutdown
+	DrainTimeout time.Duration `yaml:"drainTimeout"`
+	// MaxConcurrency limits the number of requests sent simultaneously
+	MaxConcurrency *int `yaml:"maxConcurrency"`
+	// Retry is the policy of retrying failed RPC requests
+	Retry *RetryPolicy `yaml:"retry"`
+	// Headers are added to every HTTP request
+	Headers map[string]string `yaml:"headers"`
+}
+
+// LoadConfig reads Config from the YAML or JSON file at path. Unknown fields are rejected
+func LoadConfig(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read config file: %w", err)
+	}
+	// JSON is valid YAML, so both are parsed the same way
+	var config Config
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return Config{}, fmt.Errorf("parse config file: %w", err)
+	}
+	return config, nil
+}
+
+// NewFromConfig creates FSProxy configured with config. Options in opts are applied after it
+func NewFromConfig(config Config, logger Logger, opts ...Option) (*FSProxy, error) {
+	if len(config.RPCURLs) == 0 {
+		return nil, errors.New("no RPC URL in config")
+	}
+	if config.InputFile == "" || config.OutputFile == "" {
+		return nil, errors.New("no input or output file in config")
+	}
+
+	configOpts, err := config.Options()
+	if err != nil {
+		return nil, err
+	}
+	return NewFSProxy(
+		config.RPCURLs[0],
+		config.InputFile,
+		config.OutputFile,
+		logger,
+		append(configOpts, opts...)...,
+	)
+}
+
+// Options returns options configured by the config except for the RPC URL and the files,
+// e.g. to pass them to NewStreamProxy
+func (c Config) Options() ([]Option, error) {
+	var opts []Option
+	if len(c.RPCURLs) > 1 {
+		var strategy URLStrategy
+		switch c.URLStrategy {
+		case "", "round-robin":
+			strategy = RoundRobin
+		case "failover":
+			strategy = Failover
+		default:
+			return nil, fmt.Errorf("invalid URL strategy: %s", c.URLStrategy)
+		}
+		opts = append(opts, WithRPCURLs(strategy, c.RPCURLs[1:]...))
+	}
+	if len(c.ExtraInputFiles) > 0 {
+		opts = append(opts, WithExtraInputFiles(c.ExtraInputFiles...))
+	}
+	if c.RequestTimeout != nil {
+		opts = append(opts, WithRequestTimeout(*c.RequestTimeout))
+	}
+	if c.DrainTimeout > 0 {
+		opts = append(opts, WithDrainTimeout(c.DrainTimeout))
+	}
+	if c.MaxConcurrency != nil {
+		opts = append(opts, WithMaxConcurrency(*c.MaxConcurrency))
+	}
+	if c.Retry != nil {
+		opts = append(opts, WithRetryPolicy(*c.Retry))
+	}
+	if len(c.Headers) > 0 {
+		header := make(http.Header, len(c.Headers))
+		for key, value := range c.Headers {
+			header.Set(key, value)
+		}
+		opts = append(opts, WithHeaders(header))
+	}
+	return opts, nil
+}
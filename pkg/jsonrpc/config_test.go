@@ -0,0 +1,191 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes data to a config file named name and returns its path
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	appendFile(t, path, data)
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	requestTimeout := 5 * time.Second
+	maxConcurrency := 4
+	want := Config{
+		RPCURLs:        []string{"http://primary", "http://secondary"},
+		URLStrategy:    "failover",
+		InputFile:      "/var/lib/proxy/request.pipe",
+		OutputFile:     "/var/lib/proxy/response.pipe",
+		RequestTimeout: &requestTimeout,
+		DrainTimeout:   time.Minute,
+		MaxConcurrency: &maxConcurrency,
+		Retry: &RetryPolicy{
+			MaxAttempts: 3,
+			BaseDelay:   100 * time.Millisecond,
+			Multiplier:  2,
+			RetryAfter:  true,
+		},
+		Headers: map[string]string{"X-Api-Key": "secret"},
+	}
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{
+			name: "yaml",
+			file: "config.yaml",
+			data: `
+rpcURLs: [http://primary, http://secondary]
+urlStrategy: failover
+inputFile: /var/lib/proxy/request.pipe
+outputFile: /var/lib/proxy/response.pipe
+requestTimeout: 5s
+drainTimeout: 1m
+maxConcurrency: 4
+retry:
+  maxAttempts: 3
+  baseDelay: 100ms
+  multiplier: 2
+  retryAfter: true
+headers:
+  X-Api-Key: secret
+`,
+		},
+		{
+			name: "json",
+			file: "config.json",
+			data: `{
+  "rpcURLs": ["http://primary", "http://secondary"],
+  "urlStrategy": "failover",
+  "inputFile": "/var/lib/proxy/request.pipe",
+  "outputFile": "/var/lib/proxy/response.pipe",
+  "requestTimeout": "5s",
+  "drainTimeout": "1m",
+  "maxConcurrency": 4,
+  "retry": {"maxAttempts": 3, "baseDelay": "100ms", "multiplier": 2, "retryAfter": true},
+  "headers": {"X-Api-Key": "secret"}
+}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := LoadConfig(writeConfig(t, tt.file, tt.data))
+			if err != nil {
+				t.Fatalf("load config: %v", err)
+			}
+			if !reflect.DeepEqual(config, want) {
+				t.Errorf("config = %+v, want %+v", config, want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "missing", path: filepath.Join(t.TempDir(), "missing.yaml")},
+		{name: "unknown field", path: writeConfig(t, "config.yaml", "rpcURL: http://localhost\n")},
+		{name: "invalid duration", path: writeConfig(t, "config.yaml", "requestTimeout: soon\n")},
+	}
+	for _, tt := range tests {
+		if _, err := LoadConfig(tt.path); err == nil {
+			t.Errorf("%s: error is nil", tt.name)
+		}
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	server, nextHeader := headerServer(t)
+	dir := t.TempDir()
+	config, err := LoadConfig(writeConfig(t, "config.yaml", `
+rpcURLs: [`+server.URL+`]
+inputFile: `+filepath.Join(dir, "input")+`
+outputFile: `+filepath.Join(dir, "output")+`
+headers:
+  X-Api-Key: secret
+`))
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+	proxy, err := NewFromConfig(config, nil, WithReadExisting())
+	if err != nil {
+		t.Fatalf("new proxy: %v", err)
+	}
+	p := wrapTestProxy(t, proxy, config.InputFile, config.OutputFile).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if key := nextHeader().Get("X-Api-Key"); key != "secret" {
+		t.Errorf("X-Api-Key = %q, want secret", key)
+	}
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+}
+
+func TestNewFromConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := Config{InputFile: filepath.Join(dir, "input"), OutputFile: filepath.Join(dir, "output")}
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{name: "no URL", config: files, want: "no RPC URL"},
+		{name: "no files", config: Config{RPCURLs: []string{"http://localhost"}}, want: "no input or output file"},
+		{
+			name: "invalid strategy",
+			config: Config{
+				RPCURLs:     []string{"http://primary", "http://secondary"},
+				URLStrategy: "random",
+				InputFile:   files.InputFile,
+				OutputFile:  files.OutputFile,
+			},
+			want: "invalid URL strategy",
+		},
+	}
+	for _, tt := range tests {
+		if _, err := NewFromConfig(tt.config, nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	requestTimeout := time.Second
+	config := Config{
+		RPCURLs:        []string{"http://primary", "http://secondary"},
+		RequestTimeout: &requestTimeout,
+		Headers:        map[string]string{"x-api-key": "secret"},
+	}
+	opts, err := config.Options()
+	if err != nil {
+		t.Fatalf("options: %v", err)
+	}
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.urlStrategy != RoundRobin || !reflect.DeepEqual(o.extraURLs, []string{"http://secondary"}) {
+		t.Errorf("extra URLs = %v with strategy %v, want [http://secondary] with round robin", o.extraURLs, o.urlStrategy)
+	}
+	if o.requestTimeout != time.Second {
+		t.Errorf("request timeout = %v, want 1s", o.requestTimeout)
+	}
+	if want := (http.Header{"X-Api-Key": []string{"secret"}}); !reflect.DeepEqual(o.header, want) {
+		t.Errorf("header = %v, want %v", o.header, want)
+	}
+}
@@ -0,0 +1,82 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+)
+
+const defaultCorrelationHeader = "X-Request-Id"
+
+type correlationIDKey struct{}
+
+// withCorrelationID returns ctx carrying the correlation id of a request
+func withCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// correlationIDFrom returns the correlation id carried by ctx, empty if there is none
+func correlationIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// lineCorrelationID returns id of the request in line if it is a string or a number,
+// otherwise a random UUID
+func lineCorrelationID(parser RequestParser, line string) string {
+	if id, ok := parseID(parser, []byte(line)); ok {
+		var value interface{}
+		if err := json.Unmarshal(id, &value); err == nil {
+			switch value := value.(type) {
+			case string:
+				if value != "" {
+					return value
+				}
+			case float64:
+				// Raw number keeps its formatting, e.g. of large integers
+				return string(id)
+			}
+		}
+	}
+	return newUUID()
+}
+
+// newUUID returns a random UUID version 4
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Reading of crypto/rand does not fail on supported platforms
+		panic(fmt.Sprintf("read random: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// loggerWith is Logger which adds its key-value pairs to every message
+type loggerWith struct {
+	logger        Logger
+	keysAndValues []interface{}
+}
+
+func (l loggerWith) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Info(msg, append(keysAndValues, l.keysAndValues...)...)
+}
+
+func (l loggerWith) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warn(msg, append(keysAndValues, l.keysAndValues...)...)
+}
+
+func (l loggerWith) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Error(msg, append(keysAndValues, l.keysAndValues...)...)
+}
+
+// lineLogger returns the logger of the line proxied with ctx, which logs its correlation id if any
+func (w *FSProxy) lineLogger(ctx context.Context) Logger {
+	id := correlationIDFrom(ctx)
+	if id == "" {
+		return w.logger
+	}
+	return loggerWith{logger: w.logger, keysAndValues: []interface{}{"correlationId", id}}
+}
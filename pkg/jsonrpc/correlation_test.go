@@ -0,0 +1,73 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestLineCorrelationID(t *testing.T) {
+	tests := []struct {
+		line string
+		want string // empty means a UUID
+	}{
+		{line: `{"jsonrpc":"2.0","id":"req-1","method":"ping"}`, want: "req-1"},
+		{line: `{"jsonrpc":"2.0","id":7,"method":"ping"}`, want: "7"},
+		{line: `{"jsonrpc":"2.0","id":12345678901234567890,"method":"ping"}`, want: "12345678901234567890"},
+		{line: `{"jsonrpc":"2.0","id":"","method":"ping"}`},
+		{line: `{"jsonrpc":"2.0","id":null,"method":"ping"}`},
+		{line: `{"jsonrpc":"2.0","method":"notify"}`},
+		{line: `not json`},
+	}
+	for _, tt := range tests {
+		got := lineCorrelationID(JSONRPCParser{}, tt.line)
+		if tt.want == "" && !uuidPattern.MatchString(got) || tt.want != "" && got != tt.want {
+			t.Errorf("correlation id of %s = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestFSProxyCorrelationID(t *testing.T) {
+	ids := make(chan string, 2)
+	handler := failMethodHandler("fail", http.StatusBadRequest)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ids <- r.Header.Get("X-Correlation-Id")
+		handler(w, r)
+	})
+	logger := &recordingLogger{}
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter")
+	p := newLoggedTestProxy(t, server.URL, logger,
+		WithCorrelationID("X-Correlation-Id"),
+		WithDeadLetterFile(deadLetterPath),
+		WithMaxConcurrency(1),
+	).start()
+
+	p.write(`{"jsonrpc":"2.0","id":"req-1","method":"ping"}`, `{"jsonrpc":"2.0","method":"fail"}`)
+	p.waitLineError()
+
+	if id := <-ids; id != "req-1" {
+		t.Errorf("header of request with id = %q, want req-1", id)
+	}
+	generated := <-ids
+	if !uuidPattern.MatchString(generated) {
+		t.Errorf("header of request without id = %q, want UUID", generated)
+	}
+	var logged []interface{}
+	for _, entry := range logger.find("Request done") {
+		logged = append(logged, entry.value("correlationId"))
+	}
+	if len(logged) != 2 || logged[0] != "req-1" || logged[1] != generated {
+		t.Errorf("logged ids = %v, want [req-1 %s]", logged, generated)
+	}
+	var record deadLetterRecord
+	if err := json.Unmarshal([]byte(readFile(t, deadLetterPath)), &record); err != nil {
+		t.Fatalf("unmarshal dead letter: %v", err)
+	}
+	if record.CorrelationID != generated {
+		t.Errorf("dead letter id = %q, want %q", record.CorrelationID, generated)
+	}
+}
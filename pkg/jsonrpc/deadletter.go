@@ -0,0 +1,51 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// deadLetterRecord is a line of the dead-letter file
+type deadLetterRecord struct {
+	Payload       string `json:"payload"`
+	Reason        string `json:"reason"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// deadLetterFile stores requests which could not be proxied so they can be replayed later
+type deadLetterFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openDeadLetterFile(path string, perm os.FileMode) (*deadLetterFile, error) {
+	file, err := openFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetterFile{file: file}, nil
+}
+
+func (f *deadLetterFile) write(payload, correlationID string, reason error) error {
+	record, err := json.Marshal(deadLetterRecord{
+		Payload:       payload,
+		Reason:        reason.Error(),
+		CorrelationID: correlationID,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	record = append(record, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err = f.file.Write(record)
+	return err
+}
+
+func (f *deadLetterFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,33 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+func TestFSProxyDeadLetter(t *testing.T) {
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter")
+	p := newTestProxy(t, unreachableURL(t), WithDeadLetterFile(deadLetterPath)).start()
+
+	p.write(rpcRequest(1, "ping"))
+	var lines []string
+	eventually(t, func() bool {
+		lines = splitLines(readFile(t, deadLetterPath))
+		return len(lines) == 1
+	}, "dead letter")
+
+	var record deadLetterRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatalf("unmarshal dead letter: %v", err)
+	}
+	if record.Payload != rpcRequest(1, "ping") {
+		t.Errorf("payload = %q, want %q", record.Payload, rpcRequest(1, "ping"))
+	}
+	if record.Reason == "" {
+		t.Error("reason is empty")
+	}
+	if output := p.output(); output != "" {
+		t.Errorf("output = %q, want empty", output)
+	}
+}
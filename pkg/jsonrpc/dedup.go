@@ -0,0 +1,19 @@
+package jsonrpc
+
+import "time"
+
+// deduplicator detects a line identical to the previous one
+type deduplicator struct {
+	window   time.Duration
+	prevLine string
+	prevAt   time.Time
+	hasPrev  bool
+}
+
+// duplicate reports whether line is the same as the previous one received within window
+// before now. Zero window means any time
+func (d *deduplicator) duplicate(line string, now time.Time) bool {
+	duplicate := d.hasPrev && line == d.prevLine && (d.window <= 0 || now.Sub(d.prevAt) <= d.window)
+	d.prevLine, d.prevAt, d.hasPrev = line, now, true
+	return duplicate
+}
@@ -0,0 +1,46 @@
+package jsonrpc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFSProxyDeduplication(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL, WithDeduplication(0), WithOrderedOutput()).start()
+
+	a, b := rpcRequest(1, "a"), rpcRequest(2, "b")
+	p.write(a, a, b, a)
+	p.waitLines(3)
+
+	if requests := recorder.received(); len(requests) != 3 {
+		t.Errorf("requests = %q, want the consecutive duplicate skipped", requests)
+	}
+	if dropped := p.Stats().Dropped[dropDuplicate]; dropped != 1 {
+		t.Errorf("dropped duplicates = %d, want 1", dropped)
+	}
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	d := &deduplicator{window: time.Second}
+	now := time.Now()
+
+	tests := []struct {
+		line string
+		at   time.Time
+		want bool
+	}{
+		{line: "a", at: now, want: false},
+		{line: "a", at: now.Add(500 * time.Millisecond), want: true},
+		// The window starts from the previous line, duplicate or not
+		{line: "a", at: now.Add(1400 * time.Millisecond), want: true},
+		{line: "a", at: now.Add(3 * time.Second), want: false},
+		{line: "b", at: now.Add(3 * time.Second), want: false},
+	}
+	for i, tt := range tests {
+		if got := d.duplicate(tt.line, tt.at); got != tt.want {
+			t.Errorf("line %d duplicate = %v, want %v", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package jsonrpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// watchFIFO reads lines of the input file which is a named pipe. Reading blocks until
+// data is written, so neither file events nor the lock file are used
+func (w *FSProxy) watchFIFO(ctx context.Context, wg *sync.WaitGroup, in *inputFile) <-chan inputLine {
+	lineStream := make(chan inputLine)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(lineStream)
+
+		// Opening for writing as well does not block waiting for a writer
+		// and makes reading not end when writers close the pipe
+		fifo, err := os.OpenFile(in.path, os.O_RDWR, 0)
+		if err != nil {
+			w.errorStream <- fmt.Errorf("open input pipe: %w", err)
+			return
+		}
+		closed := make(chan struct{})
+		defer close(closed)
+		go func() {
+			select {
+			case <-ctx.Done():
+			case <-closed:
+			}
+			// Closing unblocks reading
+			if err := fifo.Close(); err != nil {
+				w.logger.Warn("Failed to close input pipe", "error", err)
+			}
+		}()
+
+		if _, err := w.scanLines(ctx, in, fifo, -1, lineStream); err != nil && ctx.Err() == nil {
+			w.errorStream <- err
+		}
+	}()
+	return lineStream
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package jsonrpc
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestFSProxyFIFOInput(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "request.pipe")
+	if err := syscall.Mkfifo(inputPath, 0600); err != nil {
+		t.Fatalf("mkfifo: %v", err)
+	}
+	p := newTestProxyAt(t, server.URL, inputPath, filepath.Join(dir, "response.pipe"), WithMaxConcurrency(1)).start()
+
+	// Writing blocks until the proxy opens the pipe
+	p.write(rpcRequest(1, "first"))
+	p.write(rpcRequest(2, "second"))
+	lines := p.waitLines(2)
+
+	for i, want := range []string{rpcResult(1, "first"), rpcResult(2, "second")} {
+		if lines[i] != want {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+	if err := p.stop(); err != nil {
+		t.Errorf("run: %v", err)
+	}
+}
@@ -3,223 +3,1044 @@ package jsonrpc
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
+	"math"
 	"os"
-	"strings"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
-	"go.uber.org/zap"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 )
 
+const (
+	defaultRequestTimeout     = 30 * time.Second
+	defaultLockPollInterval   = 100 * time.Millisecond
+	defaultCheckpointInterval = time.Second
+	defaultIdempotencyHeader  = "Idempotency-Key"
+	// defaultMaxConcurrency bounds goroutines and buffered responses, so reading of the input
+	// pauses while the RPC server is slow
+	defaultMaxConcurrency = 100
+	defaultDirPerm        = 0755
+	defaultFilePerm       = 0666
+	// Reopening of the recreated input file is retried with exponential backoff,
+	// e.g. while it is replaced or locked by the writer
+	reopenAttempts  = 5
+	reopenBaseDelay = 50 * time.Millisecond
+	// lineErrorsBuffer is the number of errors Errors channel holds before new ones are discarded
+	lineErrorsBuffer = 100
+	// dryRunResponse is the response written instead of sending a request with WithDryRun
+	dryRunResponse = `{"dryRun":true}`
+)
+
+// Version is the version of jsonrpc-fsproxy sent in the default User-Agent header.
+// It is set at build time, e.g. with -ldflags "-X github.com/evsamsonov/jsonrpc-fsproxy/pkg/jsonrpc.Version=v1.0.0"
+var Version = "dev"
+
+// ErrDrainTimeout is returned by Run when in-flight requests
+// have not completed within the drain timeout after shutdown and were aborted
+var ErrDrainTimeout = errors.New("drain timeout exceeded")
+
+// ErrWatcherClosed is returned by Run when watching of the input file stops unexpectedly
+var ErrWatcherClosed = errors.New("watcher closed")
+
+// ErrClosed is returned by Run when the proxy is closed
+var ErrClosed = errors.New("proxy closed")
+
+// ErrLineDropped is wrapped by LineError of a line skipped without sending, e.g. by validation
+var ErrLineDropped = errors.New("line dropped")
+
+// LineError is an error of a single input line sent to Errors channel.
+// Line is empty if it is not known, e.g. for a line exceeding max size
+type LineError struct {
+	Line string
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Errors of NewFSProxy wrap one of these errors, so the failed part can be found with errors.Is
+var (
+	// ErrInputFile means the input file can not be created or opened
+	ErrInputFile = errors.New("input file")
+	// ErrOutputFile means the output file can not be created or opened
+	ErrOutputFile = errors.New("output file")
+	// ErrDeadLetterFile means the dead-letter file can not be created or opened
+	ErrDeadLetterFile = errors.New("dead-letter file")
+	// ErrWatcher means watching of the input file can not be started
+	ErrWatcher = errors.New("watcher")
+)
+
+// setupError is an error of NewFSProxy classified by kind, e.g. ErrInputFile.
+// It unwraps to the underlying error
+type setupError struct {
+	kind error
+	err  error
+}
+
+func (e *setupError) Error() string {
+	return e.err.Error()
+}
+
+func (e *setupError) Unwrap() error {
+	return e.err
+}
+
+func (e *setupError) Is(target error) bool {
+	return target == e.kind
+}
+
+// inputLine is a line read from the input file
+type inputLine struct {
+	text   string
+	offset int64 // offset of the byte following the line, negative if it is not known
+}
+
+// FSProxy passes lines of the input file to JSON-RPC server and writes responses to the output file
 type FSProxy struct {
-	inputFilePath   string
-	inputFile       *os.File
+	seq             uint64 // sequence number of the next line, first for 64-bit alignment
+	inputs          []*inputFile
+	inputReader     io.Reader // input of NewStreamProxy
 	outputFilePath  string
-	outputFile      *os.File
+	outputFile      outputWriter
+	outputSize      int64
+	outputArrayOpen bool // whether the opening bracket of OutputJSONArray is written
+	lastOutputAt    time.Time
+	stats           stats
 	outputFileMutex sync.Mutex
-	logger          *zap.Logger
-	rpcURL          string
+	closed          int32 // set to 1 by Close
+	logger          Logger
 	errorStream     chan error
-	watcher         *fsnotify.Watcher
+	reorderBuffer   *reorderBuffer
+	metrics         *metrics
+	tracer          trace.Tracer
+	deadLetter      *deadLetterFile
+	headersFile     *headersFile
+	checkpoint      *checkpoint
+	limiter         *rate.Limiter
+	breaker         *circuitBreaker
+	outputGuard     *outputGuard
+	stopStream      chan struct{} // closed by Stop
+	stopOnce        sync.Once
+	runMu           sync.Mutex
+	runDone         chan struct{} // closed when the running Run returns, nil if it is not running
+	lineErrors      chan error
+	settingsMu      sync.RWMutex
+	settings        *settings // replaced by Reload
+	options
 }
 
+// NewFSProxy creates FSProxy. Optional behaviour is configured with opts.
+// Nil logger discards logs, NewZapLogger adapts zap.Logger
 func NewFSProxy(
 	rpcURL string,
 	inputFilePath string,
 	outputFilePath string,
-	logger *zap.Logger,
+	logger Logger,
+	opts ...Option,
 ) (*FSProxy, error) {
-	var inputFile *os.File
-	if _, err := os.Stat(inputFilePath); os.IsNotExist(err) {
-		if inputFile, err = os.Create(inputFilePath); err != nil {
-			return nil, fmt.Errorf("create input file: %w", err)
-		}
-	} else {
-		if inputFile, err = os.Open(inputFilePath); err != nil {
-			return nil, fmt.Errorf("open input file: %w", err)
-		}
+	o, m, err := newOptions(rpcURL, opts)
+	if err != nil {
+		return nil, err
 	}
 
-	var outputFile *os.File
-	if _, err := os.Stat(outputFilePath); os.IsNotExist(err) {
-		if outputFile, err = os.Create(outputFilePath); err != nil {
-			return nil, fmt.Errorf("create output file: %w", err)
+	var inputs []*inputFile
+	// Files opened before an error are closed, so NewFSProxy can be retried
+	closeInputs := func() {
+		for _, in := range inputs {
+			_ = in.close()
 		}
-	} else {
-		if outputFile, err = os.OpenFile(outputFilePath, os.O_APPEND|os.O_WRONLY, os.ModeAppend); err != nil {
-			return nil, fmt.Errorf("open output file: %w", err)
+	}
+	if o.watcher == nil {
+		for _, path := range append([]string{inputFilePath}, o.extraInputFiles...) {
+			in, err := openInputFile(path, &o)
+			if err != nil {
+				closeInputs()
+				return nil, err
+			}
+			inputs = append(inputs, in)
 		}
 	}
 
-	watcher, err := fsnotify.NewWatcher()
+	outputFlag := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	if o.truncateOutput {
+		outputFlag |= os.O_TRUNC
+	}
+	if err := makeParentDir(outputFilePath, o.dirPerm); err != nil {
+		closeInputs()
+		return nil, &setupError{kind: ErrOutputFile, err: err}
+	}
+	outputFile, err := openFile(outputFilePath, outputFlag, o.filePerm)
 	if err != nil {
-		return nil, fmt.Errorf("new watcher: %w", err)
+		closeInputs()
+		return nil, &setupError{kind: ErrOutputFile, err: fmt.Errorf("open output file: %w", err)}
 	}
-	err = watcher.Add(inputFilePath)
+	outputStat, err := outputFile.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("watcher add: %w", err)
+		closeInputs()
+		_ = outputFile.Close()
+		return nil, &setupError{kind: ErrOutputFile, err: fmt.Errorf("stat output file: %w", err)}
 	}
 
-	return &FSProxy{
-		rpcURL:         rpcURL,
-		inputFile:      inputFile,
-		inputFilePath:  inputFilePath,
-		outputFile:     outputFile,
-		outputFilePath: outputFilePath,
-		logger:         logger,
-		errorStream:    make(chan error),
-		watcher:        watcher,
-	}, nil
+	proxy, err := newProxy(rpcURL, o, m, logger)
+	if err != nil {
+		closeInputs()
+		_ = outputFile.Close()
+		return nil, err
+	}
+	proxy.inputs = inputs
+	proxy.outputFile = proxy.bufferOutput(outputFile)
+	proxy.outputSize = outputStat.Size()
+	proxy.outputFilePath = outputFilePath
+	return proxy, nil
 }
 
+// NewStreamProxy creates FSProxy which reads lines from input instead of the input file
+// and writes responses to output instead of the output file, e.g. stdin and stdout.
+// Run returns once all lines of input are proxied. Reading which blocks is not interrupted
+// when Run returns, so the reading goroutine exits when Read returns.
+// Options of the input and output files, e.g. rotation, have no effect. Input and output
+// are not closed by Close
+func NewStreamProxy(
+	rpcURL string,
+	input io.Reader,
+	output io.Writer,
+	logger Logger,
+	opts ...Option,
+) (*FSProxy, error) {
+	o, m, err := newOptions(rpcURL, opts)
+	if err != nil {
+		return nil, err
+	}
+	o.outputRotationBytes = 0
+	o.outputNamer = nil
+
+	proxy, err := newProxy(rpcURL, o, m, logger)
+	if err != nil {
+		return nil, err
+	}
+	proxy.inputReader = input
+	proxy.outputFile = proxy.bufferOutput(writerOutput{Writer: output})
+	return proxy, nil
+}
+
+// newOptions applies opts and creates the HTTP client and metrics configured with them
+func newOptions(rpcURL string, opts []Option) (options, *metrics, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.httpClient == nil {
+		var err error
+		if o.httpClient, err = newHTTPClient(&o); err != nil {
+			return options{}, nil, fmt.Errorf("new HTTP client: %w", err)
+		}
+	}
+
+	var m *metrics
+	if o.metricsRegisterer != nil {
+		var err error
+		if m, err = newMetrics(o.metricsRegisterer); err != nil {
+			return options{}, nil, fmt.Errorf("new metrics: %w", err)
+		}
+	}
+	return o, m, nil
+}
+
+// newProxy creates FSProxy without the input and output, opening the other files set in o
+func newProxy(rpcURL string, o options, m *metrics, logger Logger) (*FSProxy, error) {
+	if logger == nil {
+		logger = NopLogger{}
+	}
+
+	var deadLetter *deadLetterFile
+	if o.deadLetterFilePath != "" {
+		if err := makeParentDir(o.deadLetterFilePath, o.dirPerm); err != nil {
+			return nil, &setupError{kind: ErrDeadLetterFile, err: err}
+		}
+		var err error
+		if deadLetter, err = openDeadLetterFile(o.deadLetterFilePath, o.filePerm); err != nil {
+			return nil, &setupError{kind: ErrDeadLetterFile, err: fmt.Errorf("open dead-letter file: %w", err)}
+		}
+	}
+	closeDeadLetter := func() {
+		if deadLetter != nil {
+			_ = deadLetter.Close()
+		}
+	}
+
+	var headers *headersFile
+	if o.headersFilePath != "" {
+		if err := makeParentDir(o.headersFilePath, o.dirPerm); err != nil {
+			closeDeadLetter()
+			return nil, err
+		}
+		var err error
+		if headers, err = openHeadersFile(o.headersFilePath, o.filePerm, o.headerNames); err != nil {
+			closeDeadLetter()
+			return nil, fmt.Errorf("open response headers file: %w", err)
+		}
+	}
+
+	proxy := &FSProxy{
+		logger:      logger,
+		errorStream: make(chan error),
+		stopStream:  make(chan struct{}),
+		lineErrors:  make(chan error, lineErrorsBuffer),
+		metrics:     m,
+		deadLetter:  deadLetter,
+		headersFile: headers,
+		tracer:      o.tracerProvider.Tracer(tracerName),
+		options:     o,
+	}
+	if o.orderedOutput {
+		proxy.reorderBuffer = newReorderBuffer()
+	}
+	proxy.settings = proxy.newSettings(rpcURL, &o)
+	if o.rateLimit > 0 {
+		proxy.limiter = rate.NewLimiter(rate.Limit(o.rateLimit), o.rateBurst)
+	}
+	if o.breakerThreshold > 0 {
+		proxy.breaker = newCircuitBreaker(o.breakerThreshold, o.breakerCooldown)
+	}
+	if o.outputErrorLimit > 0 {
+		proxy.outputGuard = newOutputGuard(o.outputErrorLimit, o.outputRetryInterval)
+	}
+	if o.checkpointFilePath != "" {
+		proxy.checkpoint = newCheckpoint(o.checkpointFilePath, o.filePerm)
+	}
+	return proxy, nil
+}
+
+// newSender creates the sender configured with o, which is the custom Sender if it is set
+func newSender(rpcURL string, o *options) Sender {
+	sender := o.sender
+	if sender == nil {
+		if len(o.extraURLs) == 0 {
+			sender = newDefaultSender(rpcURL, o)
+		} else {
+			senders := []Sender{newDefaultSender(rpcURL, o)}
+			for _, url := range o.extraURLs {
+				senders = append(senders, newDefaultSender(url, o))
+			}
+			sender = newMultiSender(o.urlStrategy, senders)
+		}
+	}
+	if len(o.methodRoutes) > 0 {
+		senders := make([]Sender, 0, len(o.methodRoutes))
+		for _, route := range o.methodRoutes {
+			senders = append(senders, newDefaultSender(route.rpcURL, o))
+		}
+		sender = newRouteSender(o.methodRoutes, senders, sender, o.requestParser)
+	}
+	return sender
+}
+
+// newStreamer returns sender of requests with streamed responses
+// or nil if they can not be streamed with the options
+func (w *FSProxy) newStreamer(sender Sender) streamSender {
+	streamer, ok := sender.(streamSender)
+	switch {
+	case !ok:
+		w.logger.Warn("Responses are not streamed, as only the default sender with a single RPC URL supports it")
+	case w.orderedOutput || w.outputNamer != nil || w.outputFormat != OutputNDJSON || w.maxOutputBytes > 0:
+		w.logger.Warn("Responses are not streamed, as it is not supported with the output options")
+	case len(w.responseTransforms) > 0 || w.splitBatchResponses || w.annotateResponseIDs || w.responseEnvelope:
+		w.logger.Warn("Responses are not streamed, as they are modified before writing")
+	default:
+		return streamer
+	}
+	return nil
+}
+
+// makeParentDir creates missing parent directories of path with perm
+func makeParentDir(path string, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return fmt.Errorf("create directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// newDefaultSender creates HTTPSender configured with options
+func newDefaultSender(rpcURL string, o *options) *HTTPSender {
+	sender := NewHTTPSender(rpcURL, o.httpClient)
+	sender.header = o.header
+	sender.token = o.tokenProvider
+	sender.gzip = o.gzipRequests
+	sender.idempotencyHeader = o.idempotencyHeader
+	sender.basicAuth = o.basicAuth
+	sender.method = o.httpMethod
+	sender.queryParam = o.queryParam
+	sender.userAgent = o.userAgent
+	sender.correlationHeader = o.correlationHeader
+	if len(o.bodyStatusCodes) > 0 {
+		sender.bodyStatusCodes = make(map[int]bool, len(o.bodyStatusCodes))
+		for _, code := range o.bodyStatusCodes {
+			sender.bodyStatusCodes[code] = true
+		}
+	}
+	return sender
+}
+
+// Run proxies lines of the input file until ctx is done or an error occurs. In both cases
+// it waits for in-flight requests. It returns nil if it stopped because ctx is done
 func (w *FSProxy) Run(ctx context.Context) error {
+	if atomic.LoadInt32(&w.closed) == 1 {
+		return ErrClosed
+	}
+	runDone := make(chan struct{})
+	w.runMu.Lock()
+	w.runDone = runDone
+	w.runMu.Unlock()
+	defer func() {
+		w.runMu.Lock()
+		w.runDone = nil
+		w.runMu.Unlock()
+		close(runDone)
+	}()
+	defer w.logDropped()
+	if w.checkpoint != nil {
+		stopCheckpoint := w.saveCheckpointPeriodically()
+		defer stopCheckpoint()
+	}
+	if w.heartbeatInterval > 0 {
+		stopHeartbeat := w.writeHeartbeats()
+		defer stopHeartbeat()
+	}
+	if w.outputBufferSize > 0 {
+		stopFlush := w.flushOutputPeriodically()
+		defer stopFlush()
+	}
+
+	// Reading stops on error as well as when ctx is done
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	// Requests are aborted after reading stops and in-flight ones are drained
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	// Stop stops only reading, lines which are read already are still proxied
+	readCtx, cancelRead := context.WithCancel(runCtx)
+	defer cancelRead()
+	go func() {
+		select {
+		case <-w.stopStream:
+			cancelRead()
+		case <-readCtx.Done():
+		}
+	}()
+
 	var wg sync.WaitGroup
-	lineStream := w.watchInput(ctx, &wg)
-	w.processLines(ctx, &wg, lineStream)
+	var lineStream <-chan inputLine
+	switch {
+	case w.inputReader != nil:
+		lineStream = w.watchReader(readCtx, &wg)
+	case w.watcher != nil:
+		lineStream = w.watchCustom(readCtx, &wg)
+	default:
+		lineStreams := make([]<-chan inputLine, 0, len(w.inputs))
+		for _, in := range w.inputs {
+			if in.isFIFO {
+				lineStreams = append(lineStreams, w.watchFIFO(readCtx, &wg, in))
+			} else {
+				lineStreams = append(lineStreams, w.watchInput(readCtx, &wg, in))
+			}
+		}
+		lineStream = mergeLines(readCtx, &wg, lineStreams)
+	}
+	w.processLines(runCtx, requestCtx, &wg, lineStream)
 
 	waitStream := make(chan struct{})
 	go func() {
 		wg.Wait()
-		waitStream <- struct{}{}
+		close(waitStream)
 	}()
 
+	var runErr error
 	select {
 	case <-waitStream:
 		return nil
-	case err := <-w.errorStream:
-		return err
+	case runErr = <-w.errorStream:
+		// Reading of a file closed by Close fails in several ways, they all mean the same
+		if atomic.LoadInt32(&w.closed) == 1 {
+			runErr = ErrClosed
+		}
+		cancelRun()
+	case <-ctx.Done():
 	}
-}
 
-func (w *FSProxy) Close() error {
-	err := w.inputFile.Close()
-	if err != nil {
-		return fmt.Errorf("close input file: %w", err)
-	}
-	err = w.outputFile.Close()
-	if err != nil {
-		return fmt.Errorf("close output file: %w", err)
+	// Give in-flight requests time to complete so received responses are written
+	var drainTimeout <-chan time.Time
+	if w.drainTimeout > 0 {
+		timer := time.NewTimer(w.drainTimeout)
+		defer timer.Stop()
+		drainTimeout = timer.C
+	} else {
+		cancelRequests()
 	}
-	err = w.watcher.Close()
-	if err != nil {
-		return fmt.Errorf("close watcher: %w", err)
+	// Errors are received until all goroutines are done, so sending to errorStream
+	// never blocks forever, even during shutdown
+	for {
+		select {
+		case <-waitStream:
+			return runErr
+		case err := <-w.errorStream:
+			if runErr == nil {
+				runErr = err
+			}
+		case <-drainTimeout:
+			if runErr == nil {
+				runErr = ErrDrainTimeout
+			}
+			cancelRequests()
+			drainTimeout = nil
+		}
 	}
-	return nil
 }
 
-func (w *FSProxy) watchInput(ctx context.Context, wg *sync.WaitGroup) <-chan string {
-	lineStream := make(chan string)
-	wg.Add(1)
+// saveCheckpointPeriodically saves checkpoint until the returned function is called.
+// The function saves checkpoint for the last time
+func (w *FSProxy) saveCheckpointPeriodically() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		defer close(lineStream)
-
-		// Skip old lines
-		_, err := w.inputFile.Seek(0, io.SeekEnd)
-		if err != nil {
-			w.errorStream <- fmt.Errorf("seek input: %w", err)
-			return
+		defer close(stopped)
+		ticker := time.NewTicker(w.checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := w.checkpoint.save(); err != nil {
+					w.logger.Error("Failed to save checkpoint", "error", err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		if err := w.checkpoint.save(); err != nil {
+			w.logger.Error("Failed to save checkpoint", "error", err)
 		}
+	}
+}
 
+// writeHeartbeats writes a heartbeat to the output file every heartbeatInterval
+// if nothing is written during it
+func (w *FSProxy) writeHeartbeats() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(w.heartbeatInterval)
+		defer ticker.Stop()
 		for {
 			select {
-			case <-ctx.Done():
+			case <-done:
 				return
-			case event, ok := <-w.watcher.Events:
-				if !ok {
-					return
-				}
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					if w.waitFreeLock(ctx) {
-						return
-					}
-					scanner := bufio.NewScanner(w.inputFile)
-					for scanner.Scan() {
-						line := scanner.Text()
-						lineStream <- line
-						w.logger.Info("Got new line", zap.String("line", line))
-					}
+			case <-ticker.C:
+				if err := w.writeHeartbeat(); err != nil {
+					w.logger.Error("Failed to write heartbeat", "error", err)
 				}
-			case err, ok := <-w.watcher.Errors:
-				if !ok {
-					return
-				}
-				w.errorStream <- fmt.Errorf("watcher errors: %w", err)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// flushOutputPeriodically flushes buffered responses every outputFlushInterval until stop is called.
+// Stop flushes them for the last time, so responses written before Run returns are not lost
+func (w *FSProxy) flushOutputPeriodically() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		var tick <-chan time.Time
+		if w.outputFlushInterval > 0 {
+			ticker := time.NewTicker(w.outputFlushInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+		for {
+			select {
+			case <-done:
 				return
+			case <-tick:
+				if err := w.flushOutput(); err != nil {
+					w.logger.Error("Failed to flush output", "error", err)
+				}
 			}
 		}
 	}()
+	return func() {
+		close(done)
+		<-stopped
+		if err := w.flushOutput(); err != nil {
+			w.logger.Error("Failed to flush output", "error", err)
+		}
+	}
+}
+
+// Stop stops reading of the input file and waits until Run proxies lines which are read
+// already and returns. Unlike cancelling the context of Run, it does not abort requests.
+// Run returns nil at once if it is called after Stop
+func (w *FSProxy) Stop() {
+	w.stopOnce.Do(func() { close(w.stopStream) })
+
+	w.runMu.Lock()
+	runDone := w.runDone
+	w.runMu.Unlock()
+	if runDone != nil {
+		<-runDone
+	}
+}
+
+// Errors returns channel of errors of single lines, which do not stop Run: failed requests,
+// failed writes and dropped lines. Errors are *LineError. They are discarded while the channel
+// is full, so it does not have to be read
+func (w *FSProxy) Errors() <-chan error {
+	return w.lineErrors
+}
+
+// reportLineError sends error of line to Errors channel unless it is full
+func (w *FSProxy) reportLineError(line string, err error) {
+	select {
+	case w.lineErrors <- &LineError{Line: line, Err: err}:
+	default:
+	}
+}
+
+// Stats returns counters of processed requests
+func (w *FSProxy) Stats() Stats {
+	return w.stats.snapshot()
+}
+
+// Close closes files of the proxy. Run stops with ErrClosed if it is running.
+// Calls after the first one do nothing. All files are closed even if closing one of them
+// fails, the first error is returned
+func (w *FSProxy) Close() error {
+	if !atomic.CompareAndSwapInt32(&w.closed, 0, 1) {
+		return nil
+	}
+
+	var firstErr error
+	setErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, in := range w.inputs {
+		if err := in.close(); err != nil {
+			setErr(err)
+		}
+	}
+	w.outputFileMutex.Lock()
+	if err := w.closeOutputArray(); err != nil {
+		setErr(fmt.Errorf("close output array: %w", err))
+	}
+	if err := w.outputFile.Close(); err != nil {
+		setErr(fmt.Errorf("close output file: %w", err))
+	}
+	w.outputFileMutex.Unlock()
+	if w.deadLetter != nil {
+		if err := w.deadLetter.Close(); err != nil {
+			setErr(fmt.Errorf("close dead-letter file: %w", err))
+		}
+	}
+	if w.headersFile != nil {
+		if err := w.headersFile.Close(); err != nil {
+			setErr(fmt.Errorf("close response headers file: %w", err))
+		}
+	}
+	return firstErr
+}
+
+// watcherClosedError returns the error of watching stopped because the watcher is closed
+func (w *FSProxy) watcherClosedError() error {
+	if atomic.LoadInt32(&w.closed) == 1 {
+		return ErrClosed
+	}
+	return ErrWatcherClosed
+}
+
+func (w *FSProxy) watchInput(ctx context.Context, wg *sync.WaitGroup, in *inputFile) <-chan inputLine {
+	lineStream := make(chan inputLine)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(lineStream)
+
+		if err := w.followInput(ctx, in, lineStream); err != nil && ctx.Err() == nil {
+			w.errorStream <- err
+		}
+	}()
 	return lineStream
 }
 
-func (w *FSProxy) waitFreeLock(ctx context.Context) (done bool) {
-	for {
-		if _, err := os.Stat(w.inputFilePath + ".lock"); os.IsNotExist(err) {
-			return false
+// followInput passes lines written to the input file until ctx is done or watching fails
+func (w *FSProxy) followInput(ctx context.Context, in *inputFile, lineStream chan<- inputLine) error {
+	if err := w.seekStart(in); err != nil {
+		return err
+	}
+	if w.readExisting || w.checkpoint != nil {
+		if err := w.readLines(ctx, in, lineStream); err != nil {
+			return err
 		}
+	}
+
+	for {
 		select {
 		case <-ctx.Done():
-			return true
-		case <-time.After(100 * time.Millisecond):
+			return nil
+		case event, ok := <-in.notifier.Events():
+			if !ok {
+				return w.watcherClosedError()
+			}
+			if err := w.handleEvent(ctx, in, event, lineStream); err != nil {
+				return err
+			}
+		case err, ok := <-in.notifier.Errors():
+			if !ok {
+				return w.watcherClosedError()
+			}
+			return fmt.Errorf("watcher errors: %w", err)
+		}
+	}
+}
+
+// handleEvent reads new lines of the input file after event, reopening it first if it is recreated
+func (w *FSProxy) handleEvent(ctx context.Context, in *inputFile, event fileEvent, lineStream chan<- inputLine) error {
+	switch event {
+	case fileCreated:
+		if err := w.reopenRecreated(ctx, in, lineStream); err != nil {
+			return err
 		}
+	case fileWritten:
+	default:
+		return nil
 	}
+	return w.readLines(ctx, in, lineStream)
 }
 
-func (w *FSProxy) processLines(ctx context.Context, wg *sync.WaitGroup, lineStream <-chan string) {
+// watchReader reads lines of inputReader until EOF. Reading may block, so the reading goroutine
+// is not added to wait group and does not block Run from returning. The returned stream is closed
+// when ctx is done even if reading blocks, so Stop does not wait for the next line
+func (w *FSProxy) watchReader(ctx context.Context, wg *sync.WaitGroup) <-chan inputLine {
+	readStream := make(chan inputLine)
+	go func() {
+		defer close(readStream)
+
+		if _, err := w.scanLines(ctx, nil, w.inputReader, -1, readStream); err != nil && ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case w.errorStream <- fmt.Errorf("read input: %w", err):
+			}
+		}
+	}()
+
+	lineStream := make(chan inputLine)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer close(lineStream)
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case line, ok := <-lineStream:
+			case line, ok := <-readStream:
 				if !ok {
 					return
 				}
-				wg.Add(1)
-				go func() {
-					defer wg.Done()
-					w.processLine(line)
-				}()
+				select {
+				case <-ctx.Done():
+					return
+				case lineStream <- line:
+				}
+			}
+		}
+	}()
+	return lineStream
+}
+
+// watchCustom passes lines of the custom Watcher
+func (w *FSProxy) watchCustom(ctx context.Context, wg *sync.WaitGroup) <-chan inputLine {
+	lineStream := make(chan inputLine)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(lineStream)
+
+		for line := range w.watcher.Lines(ctx) {
+			select {
+			case <-ctx.Done():
+				return
+			case lineStream <- inputLine{text: line, offset: -1}:
 			}
+			w.logger.Info("Got new line", "line", line)
 		}
 	}()
+	return lineStream
+}
+
+// seekStart sets the position in the input file the proxy starts reading from
+func (w *FSProxy) seekStart(in *inputFile) error {
+	if w.checkpoint != nil && in == w.inputs[0] {
+		offset, ok, err := w.checkpointOffset(in)
+		if err != nil {
+			return err
+		}
+		if ok {
+			in.offset = offset
+			return nil
+		}
+	}
+	if w.readExisting {
+		in.offset = 0
+		return nil
+	}
+
+	// Skip old lines
+	stat, err := in.file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat input: %w", err)
+	}
+	in.offset = stat.Size()
+	return nil
 }
 
-func (w *FSProxy) processLine(line string) {
-	resp, err := http.Post(w.rpcURL, "Content-Type: application/json", strings.NewReader(line))
+// checkpointOffset returns the saved offset of the input file if there is one.
+// The offset beyond the end of the file is reset to its start
+func (w *FSProxy) checkpointOffset(in *inputFile) (offset int64, ok bool, err error) {
+	offset, ok, err = w.checkpoint.load()
 	if err != nil {
-		w.logger.Error("Failed to send request", zap.Error(err))
-		return
+		return 0, false, fmt.Errorf("load checkpoint: %w", err)
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			w.logger.Warn("Failed to Close response body", zap.Error(err))
+	if !ok {
+		return 0, false, nil
+	}
+	stat, err := in.file.Stat()
+	if err != nil {
+		return 0, false, fmt.Errorf("stat input: %w", err)
+	}
+	if offset > stat.Size() {
+		w.logger.Info("Input file is shorter than checkpoint, reading from start", "offset", offset)
+		offset = 0
+	}
+	return offset, true, nil
+}
+
+// readLines sends new lines of the input file to lineStream
+func (w *FSProxy) readLines(ctx context.Context, in *inputFile, lineStream chan<- inputLine) error {
+	if w.writeDebounce > 0 {
+		if err := w.waitWritesSettled(ctx, in, lineStream); err != nil {
+			return err
 		}
-	}()
-	if resp.StatusCode != http.StatusOK {
-		w.logger.Error("Response status code not OK", zap.Error(err))
-		return
 	}
+	if err := w.waitFreeLock(ctx, in, lineStream); err != nil {
+		return err
+	}
+	if err := w.rewindIfTruncated(in); err != nil {
+		return err
+	}
+	return w.readRemaining(ctx, in, lineStream)
+}
+
+// readRemaining sends lines of the input file from the offset to EOF to lineStream
+func (w *FSProxy) readRemaining(ctx context.Context, in *inputFile, lineStream chan<- inputLine) error {
+	// Scanner reads ahead, so the offset is advanced only by the consumed lines
+	// rather than taken from the file position. An incomplete line or frame is read
+	// again after it is written completely, and lines already sent are not read again
+	// even if scanning fails
+	offset := in.offset
+	reader := io.NewSectionReader(in.file, offset, math.MaxInt64-offset)
+	consumed, err := w.scanLines(ctx, in, reader, offset, lineStream)
+	in.offset = offset + consumed
+	return err
+}
+
+// scanLines sends lines read from r to lineStream until EOF and returns the number of consumed bytes.
+// In is the input file r reads, nil if there is none. Offset is the position of r in the input file,
+// negative if r reads a pipe
+func (w *FSProxy) scanLines(
+	ctx context.Context,
+	in *inputFile,
+	r io.Reader,
+	offset int64,
+	lineStream chan<- inputLine,
+) (consumed int64, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, w.maxLineBytes)
+	splitter := &lineSplitter{
+		maxLineBytes: w.maxLineBytes,
+		framed:       w.inputFormat == InputFramed,
+		waitNewline:  offset >= 0,
+		onDiscard: func() {
+			w.logger.Error("Skip input line exceeding max size", "maxLineBytes", w.maxLineBytes)
+			w.drop("", dropOversize)
+		},
+	}
+	if offset >= 0 && in != nil {
+		splitter.splitState = in.split
+		defer func() { in.split = splitter.splitState }()
+	}
+	scanner.Split(splitter.split)
+	for scanner.Scan() {
+		line := inputLine{text: scanner.Text(), offset: -1}
+		// Checkpoint keeps the offset of the first input file only
+		if offset >= 0 && in != nil && in == w.inputs[0] {
+			line.offset = offset + splitter.consumed
+		}
+		select {
+		case <-ctx.Done():
+			return splitter.consumed, ctx.Err()
+		case lineStream <- line:
+		}
+		if in != nil {
+			w.logger.Info("Got new line", "line", line.text, "source", in.path)
+		} else {
+			w.logger.Info("Got new line", "line", line.text)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return splitter.consumed, fmt.Errorf("scan input: %w", err)
+	}
+	return splitter.consumed, nil
+}
+
+// reopenRecreated reads the rest of the previous input file, so lines appended to it before
+// it was renamed are not lost, and reopens the recreated one. Missing file is not an error,
+// as it is reopened on the next creation
+func (w *FSProxy) reopenRecreated(ctx context.Context, in *inputFile, lineStream chan<- inputLine) error {
+	if err := w.readRemaining(ctx, in, lineStream); err != nil {
+		return err
+	}
+	err := w.reopenInputWithRetry(ctx, in)
+	if errors.Is(err, os.ErrNotExist) {
+		w.logger.Warn("Input file disappeared after creation", "error", err)
+		return nil
+	}
+	return err
+}
+
+// reopenInputWithRetry reopens the input file retrying with exponential backoff if it fails.
+// Missing file is not retried, as it is reopened on the next creation
+func (w *FSProxy) reopenInputWithRetry(ctx context.Context, in *inputFile) error {
+	delay := reopenBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := w.reopenInput(in)
+		if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrClosed) || attempt >= reopenAttempts {
+			return err
+		}
+		w.logger.Warn("Failed to reopen input file, retrying", "error", err, "attempt", attempt, "delay", delay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// reopenInput replaces the input file handle after the file was recreated,
+// e.g. renamed by logrotate and created anew
+func (w *FSProxy) reopenInput(in *inputFile) error {
+	file, err := openFile(in.path, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open input file: %w", err)
+	}
+	// Close is called concurrently, so the new handle is closed if it wins
+	previous, err := in.replaceFile(file)
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := previous.Close(); err != nil {
+		w.logger.Warn("Failed to close previous input file", "error", err, "path", in.path)
+	}
+	in.offset = 0
+	in.split = splitState{}
+	w.logger.Info("Input file recreated, reopened it", "path", in.path)
+	return nil
+}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+// rewindIfTruncated moves to the start of the input file if it was truncated,
+// e.g. by logrotate, so new lines are read from the beginning
+func (w *FSProxy) rewindIfTruncated(in *inputFile) error {
+	offset := in.offset
+	stat, err := in.file.Stat()
 	if err != nil {
-		w.logger.Error("Failed to read response body", zap.Error(err))
-		return
+		return fmt.Errorf("stat input: %w", err)
+	}
+	if stat.Size() >= offset {
+		return nil
 	}
-	w.logger.Info("Got response", zap.ByteString("response", bodyBytes))
 
-	w.outputFileMutex.Lock()
-	defer w.outputFileMutex.Unlock()
+	w.logger.Info("Input file truncated, reading from start", "offset", offset, "path", in.path)
+	in.offset = 0
+	in.split = splitState{}
+	return nil
+}
+
+// waitWritesSettled waits until the input file is not changed for writeDebounce,
+// so a line written in several chunks is read at once
+func (w *FSProxy) waitWritesSettled(ctx context.Context, in *inputFile, lineStream chan<- inputLine) error {
+	settled := time.After(w.writeDebounce)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-in.notifier.Events():
+			if !ok {
+				return w.watcherClosedError()
+			}
+			if event == fileCreated {
+				if err := w.reopenRecreated(ctx, in, lineStream); err != nil {
+					return err
+				}
+			}
+			settled = time.After(w.writeDebounce)
+		case <-settled:
+			return nil
+		}
+	}
+}
 
-	if _, err := w.outputFile.Write(bodyBytes); err != nil {
-		w.logger.Error("Failed to write response", zap.Error(err))
-		return
+// waitFreeLock waits until the lock file of the input file is removed.
+// Removal is noticed by the watcher, polling is a fallback for missed events
+func (w *FSProxy) waitFreeLock(ctx context.Context, in *inputFile, lineStream chan<- inputLine) error {
+	lockFilePath := in.path + ".lock"
+	for {
+		if _, err := os.Stat(lockFilePath); os.IsNotExist(err) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-in.notifier.Events():
+			if !ok {
+				return w.watcherClosedError()
+			}
+			if event == fileCreated {
+				if err := w.reopenRecreated(ctx, in, lineStream); err != nil {
+					return err
+				}
+			}
+		case <-time.After(w.lockPollInterval):
+		}
 	}
 }
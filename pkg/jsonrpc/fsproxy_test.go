@@ -0,0 +1,533 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFSProxyInputTruncated(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL).start()
+
+	p.write(rpcRequest(1, "before-truncation"))
+	p.waitLines(1)
+	if err := os.Truncate(p.inputPath, 0); err != nil {
+		t.Fatalf("truncate input: %v", err)
+	}
+	p.write(rpcRequest(2, "after"))
+
+	if lines := p.waitLines(2); lines[1] != rpcResult(2, "after") {
+		t.Errorf("output after truncation = %q, want %q", lines[1], rpcResult(2, "after"))
+	}
+}
+
+func TestFSProxyInputRecreated(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL).start()
+
+	p.write(rpcRequest(1, "before-rotation"))
+	p.waitLines(1)
+	if err := os.Rename(p.inputPath, p.inputPath+".1"); err != nil {
+		t.Fatalf("rename input: %v", err)
+	}
+	p.write(rpcRequest(2, "after-rotation"))
+
+	if lines := p.waitLines(2); lines[1] != rpcResult(2, "after-rotation") {
+		t.Errorf("output after rotation = %q, want %q", lines[1], rpcResult(2, "after-rotation"))
+	}
+}
+
+func TestFSProxyInputRotatedBeforeRead(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	tests := []struct {
+		name string
+		opts []Option
+		// wait makes the proxy wait in the state the file is renamed in
+		wait    func(p *testProxy, notifier *fakeNotifier)
+		release func(p *testProxy, notifier *fakeNotifier)
+	}{
+		{name: "watching"},
+		{
+			name: "waiting for lock",
+			opts: []Option{WithLockPollInterval(time.Hour)},
+			wait: func(p *testProxy, notifier *fakeNotifier) {
+				appendFile(t, p.inputPath+".lock", "")
+				notifier.events <- fileWritten
+			},
+			release: func(p *testProxy, notifier *fakeNotifier) {
+				if err := os.Remove(p.inputPath + ".lock"); err != nil {
+					t.Fatalf("remove lock: %v", err)
+				}
+				notifier.events <- lockReleased
+			},
+		},
+		{
+			name: "debouncing writes",
+			opts: []Option{WithWriteDebounce(100 * time.Millisecond)},
+			wait: func(p *testProxy, notifier *fakeNotifier) {
+				notifier.events <- fileWritten
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, server.URL, append([]Option{WithOrderedOutput()}, tt.opts...)...)
+			notifier := useFakeNotifier(t, p)
+			// The existing line is read, so the proxy reads further lines on events only
+			p.write(rpcRequest(1, "existing"))
+			p.start()
+			p.waitLines(1)
+
+			// Lines are written and the file is renamed before the proxy reads them
+			p.write(rpcRequest(2, "first"), rpcRequest(3, "second"))
+			if tt.wait != nil {
+				tt.wait(p, notifier)
+			}
+			if err := os.Rename(p.inputPath, p.inputPath+".1"); err != nil {
+				t.Fatalf("rename input: %v", err)
+			}
+			p.write(rpcRequest(4, "after-rotation"))
+			notifier.events <- fileCreated
+			if tt.release != nil {
+				tt.release(p, notifier)
+			}
+
+			want := []string{
+				rpcResult(1, "existing"),
+				rpcResult(2, "first"),
+				rpcResult(3, "second"),
+				rpcResult(4, "after-rotation"),
+			}
+			if lines := p.waitLines(4); !reflect.DeepEqual(lines, want) {
+				t.Errorf("output = %q, want %q", lines, want)
+			}
+		})
+	}
+}
+
+func TestFSProxyCloseWhileInputRecreated(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	for i := 0; i < 10; i++ {
+		p := newTestProxy(t, server.URL).start()
+		stopped := make(chan struct{})
+		rotated := make(chan struct{})
+		go func() {
+			defer close(rotated)
+			for n := 0; ; n++ {
+				select {
+				case <-stopped:
+					return
+				default:
+				}
+				_ = os.Rename(p.inputPath, fmt.Sprintf("%s.%d", p.inputPath, n))
+				p.write(rpcRequest(n, "ping"))
+				time.Sleep(time.Millisecond)
+			}
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		if err := p.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		err := p.wait()
+		close(stopped)
+		<-rotated
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("run = %v, want %v", err, ErrClosed)
+		}
+	}
+}
+
+func TestFSProxyWaitsForLockRelease(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	// Polling is too slow to notice the removal within the test, so it is noticed by the watcher
+	p := newTestProxy(t, server.URL, WithLockPollInterval(time.Hour))
+	lockPath := p.inputPath + ".lock"
+	appendFile(t, lockPath, "")
+	p.start()
+
+	p.write(rpcRequest(1, "locked"))
+	time.Sleep(100 * time.Millisecond)
+	if output := p.output(); output != "" {
+		t.Fatalf("output while locked = %q, want empty", output)
+	}
+	if err := os.Remove(lockPath); err != nil {
+		t.Fatalf("remove lock: %v", err)
+	}
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "locked") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "locked"))
+	}
+}
+
+func TestFSProxyLargeLine(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithMaxLineBytes(1<<20)).start()
+	method := strings.Repeat("a", 200<<10)
+
+	p.write(rpcRequest(1, method))
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, method) {
+		t.Errorf("output of %d bytes, want the response of %d bytes", len(lines[0]), len(rpcResult(1, method)))
+	}
+}
+
+func TestFSProxyExistingLines(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+
+	t.Run("read", func(t *testing.T) {
+		dir := t.TempDir()
+		inputPath := filepath.Join(dir, "input")
+		appendFile(t, inputPath, rpcRequest(1, "existing")+"\n")
+		p := newTestProxyAt(t, server.URL, inputPath, filepath.Join(dir, "output"), WithReadExisting()).start()
+
+		if lines := p.waitLines(1); lines[0] != rpcResult(1, "existing") {
+			t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "existing"))
+		}
+	})
+	t.Run("skipped", func(t *testing.T) {
+		dir := t.TempDir()
+		inputPath := filepath.Join(dir, "input")
+		appendFile(t, inputPath, rpcRequest(1, "existing")+"\n")
+		p := newTestProxyAt(t, server.URL, inputPath, filepath.Join(dir, "output")).start()
+
+		// Lines written before Run skips the existing ones are skipped as well,
+		// so new lines are written until one of them is proxied
+		var lines []string
+		eventually(t, func() bool {
+			p.write(rpcRequest(2, "new"))
+			time.Sleep(50 * time.Millisecond)
+			lines = splitLines(p.output())
+			return len(lines) > 0
+		}, "a new line to be proxied")
+		if lines[0] != rpcResult(2, "new") {
+			t.Errorf("output = %q, want %q", lines[0], rpcResult(2, "new"))
+		}
+	})
+}
+
+func TestFSProxyAndStreamProxyShareThePipeline(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	lines := []string{rpcRequest(1, "first"), "invalid", rpcRequest(2, "second")}
+	opts := []Option{WithOrderedOutput(), WithInputValidation()}
+	want := rpcResult(1, "first") + "\n" + rpcResult(2, "second") + "\n"
+
+	p := newTestProxy(t, server.URL, opts...).start()
+	p.write(lines...)
+	p.waitLines(2)
+	if output := p.output(); output != want {
+		t.Errorf("file output = %q, want %q", output, want)
+	}
+
+	var output bytes.Buffer
+	stream, err := NewStreamProxy(server.URL, strings.NewReader(strings.Join(lines, "\n")+"\n"), &output, nil, opts...)
+	if err != nil {
+		t.Fatalf("new stream proxy: %v", err)
+	}
+	defer stream.Close()
+	if err := stream.Run(context.Background()); err != nil {
+		t.Fatalf("run stream proxy: %v", err)
+	}
+	if output.String() != want {
+		t.Errorf("stream output = %q, want %q", output.String(), want)
+	}
+	if p.Stats().Dropped[dropInvalid] != 1 || stream.Stats().Dropped[dropInvalid] != 1 {
+		t.Error("invalid line is not dropped by both proxies")
+	}
+}
+
+func TestFSProxyWriteDebounce(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL, WithWriteDebounce(100*time.Millisecond)).start()
+	line := rpcRequest(1, "chunked") + "\n"
+
+	for i := 0; i < len(line); i += 10 {
+		end := i + 10
+		if end > len(line) {
+			end = len(line)
+		}
+		p.writeRaw(line[i:end])
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.waitLines(1)
+
+	if requests := recorder.received(); len(requests) != 1 || requests[0] != rpcRequest(1, "chunked") {
+		t.Errorf("requests = %q, want the line sent once", requests)
+	}
+}
+
+func TestFSProxyCloseWithoutRun(t *testing.T) {
+	p := newTestProxy(t, unreachableURL(t))
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	if err := p.Run(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("run after close = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestFSProxyCloseWhileRunning(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL).start()
+	p.write(rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := p.wait(); !errors.Is(err, ErrClosed) {
+		t.Errorf("run = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestNewFSProxyCreatesDirectories(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	dir := t.TempDir()
+	deadLetterPath := filepath.Join(dir, "failed", "2022", "dead-letter")
+	p := newTestProxyAt(t, server.URL,
+		filepath.Join(dir, "requests", "2022", "input"),
+		filepath.Join(dir, "responses", "2022", "output"),
+		WithDeadLetterFile(deadLetterPath),
+		WithReadExisting(),
+	)
+
+	for _, path := range []string{p.inputPath, p.outputPath, deadLetterPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("stat %s: %v", path, err)
+		}
+	}
+	p.start()
+	p.write(rpcRequest(1, "ping"))
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+}
+
+func TestNewFSProxyDirectoryError(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	appendFile(t, notDir, "")
+
+	_, err := NewFSProxy("http://localhost", filepath.Join(dir, "input"), filepath.Join(notDir, "output"), nil)
+	if err == nil || !strings.Contains(err.Error(), notDir) {
+		t.Errorf("error = %v, want error with directory %s", err, notDir)
+	}
+}
+
+func TestNewFSProxyErrorKind(t *testing.T) {
+	dir := t.TempDir()
+	notDir := filepath.Join(dir, "file")
+	appendFile(t, notDir, "")
+	input, output := filepath.Join(dir, "input"), filepath.Join(dir, "output")
+	kinds := []error{ErrInputFile, ErrOutputFile, ErrDeadLetterFile}
+	tests := []struct {
+		name   string
+		input  string
+		output string
+		opts   []Option
+		want   error
+	}{
+		{name: "input", input: filepath.Join(notDir, "input"), output: output, want: ErrInputFile},
+		{name: "output", input: input, output: filepath.Join(notDir, "output"), want: ErrOutputFile},
+		{
+			name:   "dead letter",
+			input:  input,
+			output: output,
+			opts:   []Option{WithDeadLetterFile(filepath.Join(notDir, "dead-letter"))},
+			want:   ErrDeadLetterFile,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewFSProxy("http://localhost", tt.input, tt.output, nil, tt.opts...)
+			if err == nil {
+				t.Fatal("error is nil")
+			}
+			for _, kind := range kinds {
+				if got := errors.Is(err, kind); got != (kind == tt.want) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, kind, got)
+				}
+			}
+			// The underlying error is kept
+			var pathErr *os.PathError
+			if !errors.As(err, &pathErr) {
+				t.Errorf("error %v does not wrap *os.PathError", err)
+			}
+		})
+	}
+}
+
+func TestFSProxyErrors(t *testing.T) {
+	server := newRPCServer(t, failMethodHandler("fail", http.StatusInternalServerError))
+	p := newTestProxy(t, server.URL, WithInputValidation(), WithMaxConcurrency(1)).start()
+
+	p.write("invalid", rpcRequest(2, "fail"))
+	dropped := p.waitLineError()
+	failed := p.waitLineError()
+
+	if dropped.Line != "invalid" || !errors.Is(dropped, ErrLineDropped) {
+		t.Errorf("first error = %q: %v, want dropped invalid line", dropped.Line, dropped)
+	}
+	var statusErr *StatusError
+	if failed.Line != rpcRequest(2, "fail") || !errors.As(failed, &statusErr) ||
+		statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("second error = %q: %v, want status error 500", failed.Line, failed)
+	}
+
+	// Errors of single lines do not stop the proxy
+	p.write(rpcRequest(3, "succeed"))
+	if lines := p.waitLines(1); lines[0] != rpcResult(3, "succeed") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(3, "succeed"))
+	}
+}
+
+func TestFSProxyErrorsNotRead(t *testing.T) {
+	server := newRPCServer(t, failMethodHandler("fail", http.StatusInternalServerError))
+	p := newTestProxy(t, server.URL).start()
+
+	// Errors are discarded once the channel is full, so proxying goes on
+	lines := make([]string, 0, lineErrorsBuffer+10)
+	for i := 0; i < cap(lines); i++ {
+		lines = append(lines, rpcRequest(i, "fail"))
+	}
+	p.write(lines...)
+	eventually(t, func() bool {
+		return p.Stats().Failed == int64(len(lines))
+	}, "%d failed lines", len(lines))
+
+	if buffered := len(p.Errors()); buffered != lineErrorsBuffer {
+		t.Errorf("buffered errors = %d, want %d", buffered, lineErrorsBuffer)
+	}
+}
+
+// closeRecorder is a reader and a writer which records whether it is closed
+type closeRecorder struct {
+	io.Reader
+	io.Writer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestStreamProxy(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	var requests, want []string
+	for i := 0; i < 50; i++ {
+		requests = append(requests, rpcRequest(i, fmt.Sprintf("method-%d", i)))
+		want = append(want, rpcResult(i, fmt.Sprintf("method-%d", i)))
+	}
+	input := &closeRecorder{Reader: strings.NewReader(strings.Join(requests, "\n") + "\n")}
+	output := &closeRecorder{Writer: &bytes.Buffer{}}
+	stream, err := NewStreamProxy(server.URL, input, output, nil, WithOrderedOutput())
+	if err != nil {
+		t.Fatalf("new stream proxy: %v", err)
+	}
+
+	// Run returns once all lines of input are proxied
+	if err := stream.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if lines := splitLines(output.Writer.(*bytes.Buffer).String()); !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+	if input.closed || output.closed {
+		t.Error("input or output is closed")
+	}
+}
+
+func TestStreamProxyLastLineWithoutNewline(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	var output bytes.Buffer
+	stream, err := NewStreamProxy(server.URL, strings.NewReader(rpcRequest(1, "ping")), &output, nil)
+	if err != nil {
+		t.Fatalf("new stream proxy: %v", err)
+	}
+	defer stream.Close()
+
+	if err := stream.Run(context.Background()); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := rpcResult(1, "ping") + "\n"; output.String() != want {
+		t.Errorf("output = %q, want %q", output.String(), want)
+	}
+}
+
+func TestFSProxyManySmallWrites(t *testing.T) {
+	const lines = 500
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL).start()
+
+	// Every line is a write event of its own, some arrive while earlier ones are read
+	want := make(map[string]int, lines)
+	for i := 0; i < lines; i++ {
+		line := rpcRequest(i, "ping")
+		p.write(line)
+		want[line] = 1
+	}
+	eventually(t, func() bool {
+		return len(recorder.received()) >= lines
+	}, "%d requests", lines)
+	p.waitLines(lines)
+	if err := p.stop(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	got := make(map[string]int, lines)
+	for _, request := range recorder.received() {
+		got[request]++
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requests differ from lines: %d distinct of %d, want each line once", len(got), len(recorder.received()))
+	}
+	if output := splitLines(p.output()); len(output) != lines {
+		t.Errorf("output lines = %d, want %d", len(output), lines)
+	}
+}
+
+func TestFSProxyExtraInputFiles(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	dir := t.TempDir()
+	extraPaths := []string{filepath.Join(dir, "producer-1"), filepath.Join(dir, "producer-2")}
+	p := newTestProxy(t, server.URL, WithExtraInputFiles(extraPaths...)).start()
+
+	p.write(rpcRequest(1, "main"))
+	appendFile(t, extraPaths[0], rpcRequest(2, "first")+"\n")
+	appendFile(t, extraPaths[1], rpcRequest(3, "second")+"\n")
+	lines := p.waitLines(3)
+
+	sort.Strings(lines)
+	want := []string{rpcResult(1, "main"), rpcResult(2, "first"), rpcResult(3, "second")}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+
+	// Each input file is watched after its first lines as well
+	appendFile(t, extraPaths[1], rpcRequest(4, "again")+"\n")
+	if lines := p.waitLines(4); lines[3] != rpcResult(4, "again") {
+		t.Errorf("output = %q, want %q", lines[3], rpcResult(4, "again"))
+	}
+}
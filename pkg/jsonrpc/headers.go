@@ -0,0 +1,81 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// headerCaptureKey is the context key of the header captured by HTTPSender
+type headerCaptureKey struct{}
+
+// withHeaderCapture returns context making HTTPSender store response header to header
+func withHeaderCapture(ctx context.Context, header *http.Header) context.Context {
+	return context.WithValue(ctx, headerCaptureKey{}, header)
+}
+
+// captureHeader stores header to the place set by withHeaderCapture, if any
+func captureHeader(ctx context.Context, header http.Header) {
+	if capture, ok := ctx.Value(headerCaptureKey{}).(*http.Header); ok {
+		*capture = header.Clone()
+	}
+}
+
+// headersRecord is a line of the response headers file
+type headersRecord struct {
+	TS      time.Time       `json:"ts"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Status  int             `json:"status"`
+	Headers http.Header     `json:"headers"`
+}
+
+// headersFile stores headers of HTTP responses for debugging
+type headersFile struct {
+	mu    sync.Mutex
+	file  *os.File
+	names []string // names of stored headers, all are stored if empty
+}
+
+func openHeadersFile(path string, perm os.FileMode, names []string) (*headersFile, error) {
+	file, err := openFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &headersFile{file: file, names: names}, nil
+}
+
+func (f *headersFile) write(id json.RawMessage, status int, header http.Header) error {
+	if len(f.names) > 0 {
+		selected := make(http.Header, len(f.names))
+		for _, name := range f.names {
+			if values := header.Values(name); len(values) > 0 {
+				selected[http.CanonicalHeaderKey(name)] = values
+			}
+		}
+		header = selected
+	}
+	record, err := json.Marshal(headersRecord{
+		TS:      time.Now(),
+		ID:      id,
+		Status:  status,
+		Headers: header,
+	})
+	if err != nil {
+		return fmt.Errorf("marshal record: %w", err)
+	}
+	record = append(record, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err = f.file.Write(record)
+	return err
+}
+
+func (f *headersFile) Close() error {
+	return f.file.Close()
+}
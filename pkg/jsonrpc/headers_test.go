@@ -0,0 +1,67 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFSProxyResponseHeadersFile(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Trace-Id", "trace-1")
+		w.Header().Set("X-Internal", "hidden")
+		echoHandler(w, r)
+	})
+	tests := []struct {
+		name  string
+		names []string
+		want  http.Header
+	}{
+		{
+			name:  "selected",
+			names: []string{"x-trace-id", "Content-Type"},
+			want:  http.Header{"X-Trace-Id": {"trace-1"}, "Content-Type": {"application/json"}},
+		},
+		{
+			name: "all",
+			want: http.Header{"X-Trace-Id": {"trace-1"}, "X-Internal": {"hidden"}, "Content-Type": {"application/json"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headersPath := filepath.Join(t.TempDir(), "headers")
+			p := newTestProxy(t, server.URL, WithResponseHeadersFile(headersPath, tt.names...)).start()
+
+			p.write(rpcRequest(7, "ping"))
+			var lines []string
+			eventually(t, func() bool {
+				lines = splitLines(readFile(t, headersPath))
+				return len(lines) == 1
+			}, "headers record")
+
+			var record headersRecord
+			if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+				t.Fatalf("unmarshal %q: %v", lines[0], err)
+			}
+			if string(record.ID) != "7" || record.Status != http.StatusOK || record.TS.IsZero() {
+				t.Errorf("record = %q, want id 7, status 200 and ts", lines[0])
+			}
+			// Without names all headers are written, including ones the server adds itself, e.g. Date
+			headers := record.Headers
+			if tt.names == nil {
+				if record.Headers.Get("Date") == "" {
+					t.Error("Date header is not written")
+				}
+				headers = http.Header{}
+				for name := range tt.want {
+					headers[name] = record.Headers[name]
+				}
+			}
+			if !reflect.DeepEqual(headers, tt.want) {
+				t.Errorf("headers = %v, want %v", record.Headers, tt.want)
+			}
+		})
+	}
+}
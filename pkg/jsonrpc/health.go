@@ -0,0 +1,40 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// HealthHandler returns handler of liveness and readiness probes.
+// /healthz always responds with 200. /readyz responds with 503 if the proxy is closed
+// or the output file is unwritable or the last line failed to be proxied, and with 200 otherwise
+func (w *FSProxy) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		writeProbe(rw, http.StatusOK, "ok")
+	})
+	mux.HandleFunc("/readyz", func(rw http.ResponseWriter, _ *http.Request) {
+		if atomic.LoadInt32(&w.closed) == 1 {
+			writeProbe(rw, http.StatusServiceUnavailable, "proxy is closed")
+			return
+		}
+		if err := w.outputGuard.err(); err != nil {
+			writeProbe(rw, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		stats := w.Stats()
+		if stats.LastErrorAt.After(stats.LastResponseAt) {
+			writeProbe(rw, http.StatusServiceUnavailable, fmt.Sprintf("last request failed: %v", stats.LastError))
+			return
+		}
+		writeProbe(rw, http.StatusOK, "ok")
+	})
+	return mux
+}
+
+func writeProbe(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.WriteHeader(status)
+	_, _ = fmt.Fprintln(rw, message)
+}
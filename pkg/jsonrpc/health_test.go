@@ -0,0 +1,49 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// probe returns the status code handler responds with to GET request of path
+func probe(handler http.Handler, path string) int {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder.Code
+}
+
+func TestFSProxyHealthHandler(t *testing.T) {
+	server := newRPCServer(t, failMethodHandler("fail", http.StatusInternalServerError))
+	p := newTestProxy(t, server.URL, WithMaxConcurrency(1)).start()
+	handler := p.HealthHandler()
+
+	if code := probe(handler, "/readyz"); code != http.StatusOK {
+		t.Errorf("readiness before requests = %d, want 200", code)
+	}
+
+	p.write(rpcRequest(1, "fail"))
+	p.waitLineError()
+	if code := probe(handler, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness after a failure = %d, want 503", code)
+	}
+	if code := probe(handler, "/healthz"); code != http.StatusOK {
+		t.Errorf("liveness after a failure = %d, want 200", code)
+	}
+
+	p.write(rpcRequest(2, "succeed"))
+	p.waitLines(1)
+	eventually(t, func() bool {
+		return probe(handler, "/readyz") == http.StatusOK
+	}, "readiness after a success")
+
+	if err := p.stop(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if code := probe(handler, "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness after close = %d, want 503", code)
+	}
+}
@@ -0,0 +1,425 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitTimeout bounds waiting for asynchronous results of the proxy
+const waitTimeout = 10 * time.Second
+
+// testProxy is FSProxy with the input and output files in a temporary directory
+type testProxy struct {
+	*FSProxy
+	t          *testing.T
+	dir        string
+	inputPath  string
+	outputPath string
+	cancel     context.CancelFunc
+	done       chan error
+}
+
+// newTestProxy creates testProxy. Lines written before Run starts are read as well,
+// so the test does not depend on when Run seeks to the end of the input file
+func newTestProxy(t *testing.T, rpcURL string, opts ...Option) *testProxy {
+	t.Helper()
+	return newLoggedTestProxy(t, rpcURL, nil, opts...)
+}
+
+// newLoggedTestProxy is newTestProxy which logs to logger
+func newLoggedTestProxy(t *testing.T, rpcURL string, logger Logger, opts ...Option) *testProxy {
+	t.Helper()
+	dir := t.TempDir()
+	inputPath, outputPath := filepath.Join(dir, "input"), filepath.Join(dir, "output")
+	proxy, err := NewFSProxy(rpcURL, inputPath, outputPath, logger, append([]Option{WithReadExisting()}, opts...)...)
+	if err != nil {
+		t.Fatalf("new proxy: %v", err)
+	}
+	return wrapTestProxy(t, proxy, inputPath, outputPath)
+}
+
+// newTestProxyAt creates testProxy with the given input and output files
+func newTestProxyAt(t *testing.T, rpcURL, inputPath, outputPath string, opts ...Option) *testProxy {
+	t.Helper()
+	proxy, err := NewFSProxy(rpcURL, inputPath, outputPath, nil, opts...)
+	if err != nil {
+		t.Fatalf("new proxy: %v", err)
+	}
+	return wrapTestProxy(t, proxy, inputPath, outputPath)
+}
+
+// wrapTestProxy creates testProxy of proxy created with the given input and output files
+func wrapTestProxy(t *testing.T, proxy *FSProxy, inputPath, outputPath string) *testProxy {
+	t.Helper()
+	p := &testProxy{
+		FSProxy:    proxy,
+		t:          t,
+		dir:        filepath.Dir(inputPath),
+		inputPath:  inputPath,
+		outputPath: outputPath,
+	}
+	t.Cleanup(func() {
+		if p.done != nil {
+			p.cancel()
+			<-p.done
+		}
+		_ = proxy.Close()
+	})
+	return p
+}
+
+// start runs the proxy in background until stop is called or the test ends
+func (p *testProxy) start() *testProxy {
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan error, 1)
+	go func() {
+		p.done <- p.Run(ctx)
+	}()
+	return p
+}
+
+// stop cancels Run and returns its error
+func (p *testProxy) stop() error {
+	p.t.Helper()
+	p.cancel()
+	return p.wait()
+}
+
+// wait waits until Run returns and returns its error
+func (p *testProxy) wait() error {
+	p.t.Helper()
+	select {
+	case err := <-p.done:
+		p.done = nil
+		return err
+	case <-time.After(waitTimeout):
+		p.t.Fatal("Run has not returned")
+		return nil
+	}
+}
+
+// write appends lines to the input file in a single write
+func (p *testProxy) write(lines ...string) {
+	p.t.Helper()
+	p.writeRaw(strings.Join(lines, "\n") + "\n")
+}
+
+// writeRaw appends data to the input file as is
+func (p *testProxy) writeRaw(data string) {
+	p.t.Helper()
+	appendFile(p.t, p.inputPath, data)
+}
+
+// output returns the content of the output file
+func (p *testProxy) output() string {
+	p.t.Helper()
+	return readFile(p.t, p.outputPath)
+}
+
+// waitLines waits until the output file has n lines and returns them
+func (p *testProxy) waitLines(n int) []string {
+	p.t.Helper()
+	var lines []string
+	eventually(p.t, func() bool {
+		lines = splitLines(p.output())
+		return len(lines) >= n
+	}, "%d output lines", n)
+	return lines
+}
+
+// appendFile appends data to the file at path creating it if needed
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	if _, err := file.WriteString(data); err != nil {
+		_ = file.Close()
+		t.Fatalf("write %s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("close %s: %v", path, err)
+	}
+}
+
+// readFile returns the content of the file at path, empty if it does not exist
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+// splitLines returns non-empty lines of s. A line which is not terminated yet,
+// e.g. as the writer is in progress, is not returned
+func splitLines(s string) []string {
+	s = s[:strings.LastIndex(s, "\n")+1]
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// eventually fails the test if cond does not become true within waitTimeout
+func eventually(t *testing.T, cond func() bool, format string, args ...interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for "+format, args...)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newRPCServer starts a test server closed at the end of the test
+func newRPCServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// echoHandler replies to a JSON-RPC request with a result equal to its method.
+// A batch request is replied with a batch of such responses
+func echoHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	response, err := echoResponse(body)
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(response)
+}
+
+// failMethodHandler replies with status to requests of method and with echoHandler to others
+func failMethodHandler(method string, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		var request struct {
+			Method string `json:"method"`
+		}
+		if json.Unmarshal(body, &request) == nil && request.Method == method {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		echoHandler(w, r)
+	}
+}
+
+// echoResponse returns the response of echoHandler to payload
+func echoResponse(payload []byte) ([]byte, error) {
+	type echoRequest struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+	}
+	type echoReply struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  string          `json:"result"`
+	}
+	if isBatch(payload) {
+		var requests []echoRequest
+		if err := json.Unmarshal(payload, &requests); err != nil {
+			return nil, err
+		}
+		responses := make([]echoReply, 0, len(requests))
+		for _, request := range requests {
+			responses = append(responses, echoReply{"2.0", request.ID, request.Method})
+		}
+		return json.Marshal(responses)
+	}
+	var request echoRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, err
+	}
+	return json.Marshal(echoReply{"2.0", request.ID, request.Method})
+}
+
+// rpcRequest returns a JSON-RPC request line with id and method
+func rpcRequest(id int, method string) string {
+	line, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method})
+	return string(line)
+}
+
+// rpcResult returns the response echoHandler writes for rpcRequest(id, method)
+func rpcResult(id int, method string) string {
+	return `{"jsonrpc":"2.0","id":` + strconv.Itoa(id) + `,"result":"` + method + `"}`
+}
+
+// waitLineError returns the next error of Errors channel
+func (p *testProxy) waitLineError() *LineError {
+	p.t.Helper()
+	select {
+	case err := <-p.Errors():
+		lineErr, ok := err.(*LineError)
+		if !ok {
+			p.t.Fatalf("error %T is not *LineError", err)
+		}
+		return lineErr
+	case <-time.After(waitTimeout):
+		p.t.Fatal("timed out waiting for line error")
+		return nil
+	}
+}
+
+// sleepHandler replies after delay unless the request is cancelled before
+func sleepHandler(delay time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bufferBody(r) {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+		echoHandler(w, r)
+	}
+}
+
+// bufferBody reads the body of r into memory, so the server notices when the client
+// cancels the request while the handler waits. It reports whether the body is read
+func bufferBody(r *http.Request) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return true
+}
+
+// recordingServer is a test server which records request bodies and replies with echoHandler
+type recordingServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *recordingServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, string(body))
+	s.mu.Unlock()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	echoHandler(w, r)
+}
+
+// received returns bodies of the requests received so far
+func (s *recordingServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// logEntry is a message logged by recordingLogger
+type logEntry struct {
+	level         string
+	msg           string
+	keysAndValues []interface{}
+}
+
+// value returns the value of key, nil if there is none
+func (e logEntry) value(key string) interface{} {
+	for i := 0; i+1 < len(e.keysAndValues); i += 2 {
+		if e.keysAndValues[i] == key {
+			return e.keysAndValues[i+1]
+		}
+	}
+	return nil
+}
+
+// recordingLogger is Logger which keeps logged messages
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []logEntry
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.log("info", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log("warn", msg, keysAndValues)
+}
+
+func (l *recordingLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.log("error", msg, keysAndValues)
+}
+
+func (l *recordingLogger) log(level, msg string, keysAndValues []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, logEntry{level: level, msg: msg, keysAndValues: keysAndValues})
+}
+
+// find returns entries with msg
+func (l *recordingLogger) find(msg string) []logEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []logEntry
+	for _, entry := range l.entries {
+		if entry.msg == msg {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// unreachableURL returns URL of a server which is closed already
+func unreachableURL(t *testing.T) string {
+	t.Helper()
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+// headerServer starts a test server which replies with echoHandler. The returned function
+// returns headers of the next request
+func headerServer(t *testing.T) (*httptest.Server, func() http.Header) {
+	t.Helper()
+	headers := make(chan http.Header, 10)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		echoHandler(w, r)
+	})
+	return server, func() http.Header {
+		t.Helper()
+		select {
+		case header := <-headers:
+			return header
+		case <-time.After(waitTimeout):
+			t.Fatal("timed out waiting for request")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package jsonrpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// inputFile is an input file watched for new lines.
+// The one passed to NewFSProxy is the first of FSProxy inputs
+type inputFile struct {
+	path     string
+	file     *os.File // nil if the file is a named pipe, replaced only under mu
+	offset   int64    // offset of the next line to read
+	notifier notifier // nil if the file is a named pipe
+	isFIFO   bool
+	split    splitState
+	mu       sync.Mutex // guards file and closed, as Close is called concurrently with Run
+	closed   bool
+}
+
+// openInputFile opens the input file at path creating it if it does not exist
+func openInputFile(path string, o *options) (*inputFile, error) {
+	in := &inputFile{path: filepath.Clean(path)}
+	stat, err := os.Stat(in.path)
+	switch {
+	case os.IsNotExist(err):
+		if err := makeParentDir(in.path, o.dirPerm); err != nil {
+			return nil, &setupError{kind: ErrInputFile, err: err}
+		}
+		if in.file, err = openFile(in.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, o.filePerm); err != nil {
+			return nil, &setupError{kind: ErrInputFile, err: fmt.Errorf("create input file: %w", err)}
+		}
+	case err == nil && stat.Mode()&os.ModeNamedPipe != 0:
+		// Opening a named pipe blocks until it has a writer, so it is opened in Run
+		in.isFIFO = true
+		return in, nil
+	default:
+		if in.file, err = openFile(in.path, os.O_RDONLY, 0); err != nil {
+			return nil, &setupError{kind: ErrInputFile, err: fmt.Errorf("open input file: %w", err)}
+		}
+	}
+
+	if o.pollInterval > 0 {
+		in.notifier, err = newPollingNotifier(in.path, o.pollInterval)
+	} else {
+		in.notifier, err = newFSNotifyNotifier(in.path)
+	}
+	if err != nil {
+		return nil, &setupError{kind: ErrWatcher, err: fmt.Errorf("new notifier: %w", err)}
+	}
+	return in, nil
+}
+
+// replaceFile sets the handle of the recreated input file and returns the previous one.
+// It fails with ErrClosed if the input file is closed already
+func (in *inputFile) replaceFile(file *os.File) (*os.File, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.closed {
+		return nil, ErrClosed
+	}
+	previous := in.file
+	in.file = file
+	return previous, nil
+}
+
+func (in *inputFile) close() error {
+	in.mu.Lock()
+	in.closed = true
+	file := in.file
+	in.mu.Unlock()
+
+	var closeErr error
+	if in.notifier != nil {
+		if err := in.notifier.Close(); err != nil {
+			closeErr = fmt.Errorf("close notifier: %w", err)
+		}
+	}
+	if file != nil {
+		if err := file.Close(); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("close input file: %w", err)
+		}
+	}
+	return closeErr
+}
+
+// mergeLines passes lines of all lineStreams to the returned stream, which is closed
+// when all of them are closed
+func mergeLines(ctx context.Context, wg *sync.WaitGroup, lineStreams []<-chan inputLine) <-chan inputLine {
+	if len(lineStreams) == 1 {
+		return lineStreams[0]
+	}
+	merged := make(chan inputLine)
+	var mergeWg sync.WaitGroup
+	for _, lineStream := range lineStreams {
+		mergeWg.Add(1)
+		wg.Add(1)
+		go func(lineStream <-chan inputLine) {
+			defer wg.Done()
+			defer mergeWg.Done()
+			for line := range lineStream {
+				select {
+				case <-ctx.Done():
+					return
+				case merged <- line:
+				}
+			}
+		}(lineStream)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		mergeWg.Wait()
+		close(merged)
+	}()
+	return merged
+}
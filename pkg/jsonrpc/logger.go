@@ -0,0 +1,41 @@
+package jsonrpc
+
+import "go.uber.org/zap"
+
+// Logger is used by FSProxy to log its work. Arguments after the message are key-value pairs
+type Logger interface {
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// ZapLogger adapts zap.Logger to Logger
+type ZapLogger struct {
+	logger *zap.SugaredLogger
+}
+
+// NewZapLogger creates Logger which writes to logger
+func NewZapLogger(logger *zap.Logger) *ZapLogger {
+	return &ZapLogger{
+		logger: logger.WithOptions(zap.AddCallerSkip(1)).Sugar(),
+	}
+}
+
+func (l *ZapLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Infow(msg, keysAndValues...)
+}
+
+func (l *ZapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warnw(msg, keysAndValues...)
+}
+
+func (l *ZapLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Errorw(msg, keysAndValues...)
+}
+
+// NopLogger is Logger which discards everything
+type NopLogger struct{}
+
+func (NopLogger) Info(string, ...interface{})  {}
+func (NopLogger) Warn(string, ...interface{})  {}
+func (NopLogger) Error(string, ...interface{}) {}
@@ -0,0 +1,72 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewFSProxyNopLogger(t *testing.T) {
+	p := newTestProxy(t, "http://localhost")
+
+	if _, ok := p.logger.(NopLogger); !ok {
+		t.Errorf("logger = %T, want NopLogger", p.logger)
+	}
+}
+
+func TestFSProxyLogger(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	logger := &recordingLogger{}
+	p := newLoggedTestProxy(t, server.URL, logger).start()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	if entries := logger.find("Got response"); len(entries) != 1 {
+		t.Errorf("logged responses = %d, want 1", len(entries))
+	}
+}
+
+func TestZapLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	NewZapLogger(zap.New(core)).Warn("Skip line", "line", "{}")
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("entries = %d, want 1", len(entries))
+	}
+	if entries[0].Level != zapcore.WarnLevel || entries[0].Message != "Skip line" {
+		t.Errorf("entry = %v %q, want warn Skip line", entries[0].Level, entries[0].Message)
+	}
+	if line := entries[0].ContextMap()["line"]; line != "{}" {
+		t.Errorf("line field = %v, want {}", line)
+	}
+}
+
+func TestFSProxyLogsRequestStatusAndLatency(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	core, logs := observer.New(zapcore.InfoLevel)
+	p := newLoggedTestProxy(t, server.URL, NewZapLogger(zap.New(core))).start()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	entries := logs.FilterMessage("Request done").All()
+	if len(entries) != 1 {
+		t.Fatalf("request logs = %d, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if status := fields["status"]; status != int64(http.StatusOK) {
+		t.Errorf("status = %v, want 200", status)
+	}
+	if latency, ok := fields["latency"].(time.Duration); !ok || latency < 0 {
+		t.Errorf("latency = %v, want duration", fields["latency"])
+	}
+	if bytes := fields["bytes"]; bytes != int64(len(rpcResult(1, "ping"))) {
+		t.Errorf("bytes = %v, want %d", bytes, len(rpcResult(1, "ping")))
+	}
+}
@@ -0,0 +1,98 @@
+package jsonrpc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "jsonrpc_fsproxy"
+
+// Failure reasons of RPC requests
+const (
+	failureTransport = "transport"
+	failureStatus    = "status"
+	failureRead      = "read"
+)
+
+// Reasons of skipping input lines
+const (
+	dropOversize  = "oversize"
+	dropInvalid   = "invalid"
+	dropFiltered  = "filtered"
+	dropDuplicate = "duplicate"
+	dropOutput    = "unwritable"
+)
+
+type metrics struct {
+	requests prometheus.Counter
+	failures *prometheus.CounterVec
+	latency  prometheus.Histogram
+	dropped  *prometheus.CounterVec
+}
+
+func newMetrics(registerer prometheus.Registerer) (*metrics, error) {
+	m := &metrics{
+		requests: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Total number of RPC requests.",
+		}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_failures_total",
+			Help:      "Total number of failed RPC requests by failure reason.",
+		}, []string{"reason"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of RPC requests.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_lines_total",
+			Help:      "Total number of skipped input lines by reason.",
+		}, []string{"reason"}),
+	}
+	for _, collector := range []prometheus.Collector{m.requests, m.failures, m.latency, m.dropped} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, fmt.Errorf("register: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// observeRequest records the result of a single RPC request. It is no-op for nil metrics
+func (m *metrics) observeRequest(latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.requests.Inc()
+	m.latency.Observe(latency.Seconds())
+	if err != nil {
+		m.failures.WithLabelValues(failureReason(err)).Inc()
+	}
+}
+
+// observeDrop records an input line skipped for reason. It is no-op for nil metrics
+func (m *metrics) observeDrop(reason string) {
+	if m == nil {
+		return
+	}
+	m.dropped.WithLabelValues(reason).Inc()
+}
+
+func failureReason(err error) string {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return failureStatus
+	}
+	var readErr *ReadError
+	if errors.As(err, &readErr) {
+		return failureRead
+	}
+	return failureTransport
+}
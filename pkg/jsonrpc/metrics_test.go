@@ -0,0 +1,109 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestFSProxyMetrics(t *testing.T) {
+	server := newRPCServer(t, failMethodHandler("fail", http.StatusInternalServerError))
+	registry := prometheus.NewRegistry()
+	p := newTestProxy(t, server.URL, WithMetrics(registry)).start()
+
+	p.write(rpcRequest(1, "succeed"), rpcRequest(2, "fail"))
+	eventually(t, func() bool {
+		stats := p.Stats()
+		return stats.Processed+stats.Failed == 2
+	}, "both lines to be proxied")
+
+	if got := testutil.ToFloat64(p.metrics.requests); got != 2 {
+		t.Errorf("requests = %v, want 2", got)
+	}
+	if got := testutil.ToFloat64(p.metrics.failures.WithLabelValues(failureStatus)); got != 1 {
+		t.Errorf("status failures = %v, want 1", got)
+	}
+	if count := testutil.CollectAndCount(p.metrics.latency); count != 1 {
+		t.Errorf("latency metrics = %d, want 1", count)
+	}
+	expected := `
+# HELP jsonrpc_fsproxy_requests_total Total number of RPC requests.
+# TYPE jsonrpc_fsproxy_requests_total counter
+jsonrpc_fsproxy_requests_total 2
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "jsonrpc_fsproxy_requests_total")
+	if err != nil {
+		t.Errorf("registry: %v", err)
+	}
+}
+
+func TestFailureReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{err: &StatusError{StatusCode: http.StatusBadGateway}, want: failureStatus},
+		{err: &ReadError{Err: errRead}, want: failureRead},
+		{err: errRead, want: failureTransport},
+	}
+	for _, tt := range tests {
+		if got := failureReason(tt.err); got != tt.want {
+			t.Errorf("failureReason(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFSProxyDroppedLines(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	registry := prometheus.NewRegistry()
+	logger := &recordingLogger{}
+	allow := func(method string) bool {
+		return method != "admin_stop"
+	}
+	p := newLoggedTestProxy(t, server.URL, logger,
+		WithMetrics(registry),
+		WithJSONRPCValidation(false),
+		WithMethodFilter(allow),
+	).start()
+
+	p.write(
+		`{"id":1,"method":"no version"}`,
+		`not json`,
+		rpcRequest(3, "admin_stop"),
+		rpcRequest(4, "ping"),
+	)
+	p.waitLines(1)
+	eventually(t, func() bool {
+		dropped := p.Stats().Dropped
+		return dropped[dropInvalid]+dropped[dropFiltered] == 3
+	}, "dropped lines")
+	if err := p.stop(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if got, want := p.Stats().Dropped, map[string]int64{dropInvalid: 2, dropFiltered: 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dropped = %v, want %v", got, want)
+	}
+	expected := `
+# HELP jsonrpc_fsproxy_dropped_lines_total Total number of skipped input lines by reason.
+# TYPE jsonrpc_fsproxy_dropped_lines_total counter
+jsonrpc_fsproxy_dropped_lines_total{reason="filtered"} 1
+jsonrpc_fsproxy_dropped_lines_total{reason="invalid"} 2
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "jsonrpc_fsproxy_dropped_lines_total")
+	if err != nil {
+		t.Errorf("registry: %v", err)
+	}
+	summary := logger.find("Dropped lines")
+	if len(summary) != 1 {
+		t.Fatalf("summary logs = %d, want 1", len(summary))
+	}
+	want := []interface{}{dropFiltered, int64(1), dropInvalid, int64(2)}
+	if !reflect.DeepEqual(summary[0].keysAndValues, want) {
+		t.Errorf("summary = %v, want %v", summary[0].keysAndValues, want)
+	}
+}
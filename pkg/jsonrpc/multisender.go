@@ -0,0 +1,59 @@
+package jsonrpc
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// URLStrategy defines how a URL is chosen for a request when several RPC URLs are set
+type URLStrategy int
+
+const (
+	// RoundRobin sends requests to URLs in turn
+	RoundRobin URLStrategy = iota
+	// Failover sends requests to the first URL and tries the next one only if the previous one fails
+	Failover
+)
+
+// multiSender sends requests using one of several senders chosen by strategy
+type multiSender struct {
+	next     uint64 // index of the sender for the next round-robin request
+	strategy URLStrategy
+	senders  []Sender
+}
+
+func newMultiSender(strategy URLStrategy, senders []Sender) *multiSender {
+	return &multiSender{
+		strategy: strategy,
+		senders:  senders,
+	}
+}
+
+func (s *multiSender) Send(ctx context.Context, payload []byte) ([]byte, error) {
+	response, _, err := s.sendWithStatus(ctx, payload)
+	return response, err
+}
+
+func (s *multiSender) sendWithStatus(ctx context.Context, payload []byte) (response []byte, status int, err error) {
+	if s.strategy == RoundRobin {
+		i := (atomic.AddUint64(&s.next, 1) - 1) % uint64(len(s.senders))
+		return sendWithStatus(ctx, s.senders[i], payload)
+	}
+
+	for _, sender := range s.senders {
+		response, status, err = sendWithStatus(ctx, sender, payload)
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return response, status, err
+		}
+	}
+	return response, status, err
+}
+
+// sendWithStatus sends payload using sender and returns the status code if sender reports it
+func sendWithStatus(ctx context.Context, sender Sender, payload []byte) ([]byte, int, error) {
+	if sender, ok := sender.(statusSender); ok {
+		return sender.sendWithStatus(ctx, payload)
+	}
+	response, err := sender.Send(ctx, payload)
+	return response, 0, err
+}
@@ -0,0 +1,29 @@
+package jsonrpc
+
+import "testing"
+
+func TestFSProxyRoundRobin(t *testing.T) {
+	first, second := &recordingServer{}, &recordingServer{}
+	firstServer, secondServer := newRPCServer(t, first.handle), newRPCServer(t, second.handle)
+	p := newTestProxy(t, firstServer.URL, WithRPCURLs(RoundRobin, secondServer.URL)).start()
+
+	p.write(rpcRequest(1, "a"), rpcRequest(2, "b"), rpcRequest(3, "c"), rpcRequest(4, "d"))
+	p.waitLines(4)
+
+	if len(first.received()) != 2 || len(second.received()) != 2 {
+		t.Errorf("requests = %d and %d, want 2 to each URL", len(first.received()), len(second.received()))
+	}
+}
+
+func TestFSProxyFailover(t *testing.T) {
+	backup := &recordingServer{}
+	backupServer := newRPCServer(t, backup.handle)
+	p := newTestProxy(t, unreachableURL(t), WithRPCURLs(Failover, backupServer.URL)).start()
+
+	p.write(rpcRequest(1, "a"), rpcRequest(2, "b"))
+	p.waitLines(2)
+
+	if requests := backup.received(); len(requests) != 2 {
+		t.Errorf("requests to the backup URL = %d, want 2", len(requests))
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package jsonrpc
+
+import "os"
+
+// openFile opens the file as os.OpenFile
+func openFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(path, flag, perm)
+}
@@ -0,0 +1,68 @@
+package jsonrpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output")
+	appendFile(t, path, "old\n")
+
+	file, err := openFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := file.WriteString("new\n"); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got := readFile(t, path); got != "old\nnew\n" {
+		t.Errorf("content = %q, want %q", got, "old\nnew\n")
+	}
+}
+
+func TestOpenFileTruncate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output")
+	appendFile(t, path, "old\n")
+
+	file, err := openFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	if got := readFile(t, path); got != "" {
+		t.Errorf("content = %q, want empty", got)
+	}
+}
+
+func TestOpenFileMissing(t *testing.T) {
+	if _, err := openFile(filepath.Join(t.TempDir(), "missing"), os.O_RDONLY, 0); !os.IsNotExist(err) {
+		t.Errorf("error = %v, want not exist error", err)
+	}
+}
+
+// The input file is rotated by renaming it while the proxy keeps it open
+func TestOpenFileRenameWhileOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input")
+	file, err := openFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer file.Close()
+
+	if err := os.Rename(path, filepath.Join(dir, "input.1")); err != nil {
+		t.Fatalf("rename open file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(dir, "input.1")); err != nil {
+		t.Fatalf("remove open file: %v", err)
+	}
+}
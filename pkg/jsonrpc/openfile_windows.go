@@ -0,0 +1,53 @@
+//go:build windows
+// +build windows
+
+package jsonrpc
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFile opens the file as os.OpenFile but allows other processes to rename and delete it
+// while it is open, e.g. to rotate the input file or remove the read output file.
+// Perm is ignored, as only O_RDONLY, O_WRONLY, O_RDWR, O_APPEND, O_CREATE and O_TRUNC
+// flags are supported
+func openFile(path string, flag int, _ os.FileMode) (*os.File, error) {
+	pathp, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	var access uint32
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		access = syscall.GENERIC_WRITE
+	case os.O_RDWR:
+		access = syscall.GENERIC_READ | syscall.GENERIC_WRITE
+	default:
+		access = syscall.GENERIC_READ
+	}
+	if flag&os.O_APPEND != 0 {
+		access &^= syscall.GENERIC_WRITE
+		access |= syscall.FILE_APPEND_DATA
+	}
+
+	var createMode uint32
+	switch {
+	case flag&(os.O_CREATE|os.O_TRUNC) == os.O_CREATE|os.O_TRUNC:
+		createMode = syscall.CREATE_ALWAYS
+	case flag&os.O_CREATE != 0:
+		createMode = syscall.OPEN_ALWAYS
+	case flag&os.O_TRUNC != 0:
+		createMode = syscall.TRUNCATE_EXISTING
+	default:
+		createMode = syscall.OPEN_EXISTING
+	}
+
+	shareMode := uint32(syscall.FILE_SHARE_READ | syscall.FILE_SHARE_WRITE | syscall.FILE_SHARE_DELETE)
+	handle, err := syscall.CreateFile(pathp, access, shareMode, nil, createMode, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(handle), path), nil
+}
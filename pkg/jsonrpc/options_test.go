@@ -0,0 +1,58 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"testing"
+	"time"
+)
+
+func TestNewOptionsDefaults(t *testing.T) {
+	o, _, err := newOptions("http://localhost", nil)
+	if err != nil {
+		t.Fatalf("new options: %v", err)
+	}
+
+	if o.requestTimeout != defaultRequestTimeout {
+		t.Errorf("request timeout = %v, want %v", o.requestTimeout, defaultRequestTimeout)
+	}
+	if o.retryPolicy.MaxAttempts != 1 {
+		t.Errorf("max attempts = %d, want 1", o.retryPolicy.MaxAttempts)
+	}
+	if o.maxConcurrency != defaultMaxConcurrency {
+		t.Errorf("max concurrency = %d, want %d", o.maxConcurrency, defaultMaxConcurrency)
+	}
+	if o.maxLineBytes != bufio.MaxScanTokenSize {
+		t.Errorf("max line bytes = %d, want %d", o.maxLineBytes, bufio.MaxScanTokenSize)
+	}
+	if o.orderedOutput {
+		t.Error("output is ordered by default")
+	}
+	if o.httpClient == nil {
+		t.Error("HTTP client is not created")
+	}
+}
+
+func TestNewOptionsApply(t *testing.T) {
+	o, _, err := newOptions("http://localhost", []Option{
+		WithRequestTimeout(time.Second),
+		WithMaxConcurrency(3),
+		WithOrderedOutput(),
+		WithMaxLineBytes(1024),
+	})
+	if err != nil {
+		t.Fatalf("new options: %v", err)
+	}
+
+	if o.requestTimeout != time.Second {
+		t.Errorf("request timeout = %v, want %v", o.requestTimeout, time.Second)
+	}
+	if o.maxConcurrency != 3 {
+		t.Errorf("max concurrency = %d, want 3", o.maxConcurrency)
+	}
+	if !o.orderedOutput {
+		t.Error("output is not ordered")
+	}
+	if o.maxLineBytes != 1024 {
+		t.Errorf("max line bytes = %d, want 1024", o.maxLineBytes)
+	}
+}
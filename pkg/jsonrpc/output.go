@@ -0,0 +1,352 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// outputWriter is the output file or the output of NewStreamProxy
+type outputWriter interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// writerOutput is outputWriter which neither syncs nor closes its writer
+type writerOutput struct {
+	io.Writer
+}
+
+func (writerOutput) Sync() error {
+	return nil
+}
+
+func (writerOutput) Close() error {
+	return nil
+}
+
+// bufferedOutput is outputWriter which collects written data in a buffer.
+// Sync and Close write the buffer to the output first
+type bufferedOutput struct {
+	*bufio.Writer
+	output outputWriter
+}
+
+func (o *bufferedOutput) Sync() error {
+	if err := o.Flush(); err != nil {
+		return err
+	}
+	return o.output.Sync()
+}
+
+func (o *bufferedOutput) Close() error {
+	flushErr := o.Flush()
+	if err := o.output.Close(); err != nil {
+		return err
+	}
+	if flushErr != nil {
+		return fmt.Errorf("flush output: %w", flushErr)
+	}
+	return nil
+}
+
+// bufferOutput wraps output in bufferedOutput if it is enabled with WithBufferedOutput
+func (w *FSProxy) bufferOutput(output outputWriter) outputWriter {
+	if w.outputBufferSize <= 0 {
+		return output
+	}
+	return &bufferedOutput{Writer: bufio.NewWriterSize(output, w.outputBufferSize), output: output}
+}
+
+// flushOutput writes buffered responses to the output file
+func (w *FSProxy) flushOutput() error {
+	w.outputFileMutex.Lock()
+	defer w.outputFileMutex.Unlock()
+
+	if buffered, ok := w.outputFile.(*bufferedOutput); ok {
+		return buffered.Flush()
+	}
+	return nil
+}
+
+// output writes response messages of the line with sequence number seq.
+// Nil response means there is nothing to write
+func (w *FSProxy) output(seq uint64, response [][]byte) error {
+	if w.reorderBuffer != nil {
+		return w.reorderBuffer.push(seq, response, w.writeResponse)
+	}
+	if response == nil {
+		return nil
+	}
+	return w.writeResponse(response)
+}
+
+// OutputNamer returns the name of the file the response of request is written to.
+// Relative names are resolved against the directory of the output file
+type OutputNamer func(request []byte) (string, error)
+
+// writeNamedResponse writes response messages to the file named by outputNamer, one per line,
+// replacing the file if it exists. Nil response means there is nothing to write
+func (w *FSProxy) writeNamedResponse(request []byte, response [][]byte) error {
+	if response == nil {
+		return nil
+	}
+	name, err := w.outputNamer(request)
+	if err != nil {
+		return fmt.Errorf("name output file: %w", err)
+	}
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(w.outputFilePath), name)
+	}
+	if err := makeParentDir(name, w.dirPerm); err != nil {
+		return err
+	}
+	file, err := openFile(name, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, w.filePerm)
+	if err != nil {
+		return fmt.Errorf("open output file: %w", err)
+	}
+	var data []byte
+	for _, message := range response {
+		data = append(data, compactMessage(message)...)
+		data = append(data, '\n')
+	}
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("write output file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("close output file: %w", err)
+	}
+	return nil
+}
+
+// ErrOutputLimit is returned by Run when writing a response would make the output file
+// exceed the size set by WithMaxOutputBytes
+var ErrOutputLimit = errors.New("output file size limit exceeded")
+
+// OutputLimitAction defines what happens to responses exceeding the output file size limit
+type OutputLimitAction int
+
+const (
+	// FailOnOutputLimit makes Run fail with ErrOutputLimit
+	FailOnOutputLimit OutputLimitAction = iota
+	// DropOnOutputLimit makes responses be dropped with a warning
+	DropOnOutputLimit
+)
+
+// OutputFormat defines how responses are written to the output file
+type OutputFormat int
+
+const (
+	// OutputNDJSON writes each response as a single line
+	OutputNDJSON OutputFormat = iota
+	// OutputJSONArray writes responses as elements of a single JSON array.
+	// The array is closed when the proxy is closed or the output file is rotated
+	OutputJSONArray
+	// OutputFramed writes each response preceded by Content-Length header
+	// as in Language Server Protocol
+	OutputFramed
+)
+
+// writeResponse writes response to output file in the output format.
+// Response may consist of several messages, e.g. of a split batch response
+func (w *FSProxy) writeResponse(response [][]byte) error {
+	w.outputFileMutex.Lock()
+	defer w.outputFileMutex.Unlock()
+
+	return w.writeResponseLocked(response)
+}
+
+// writeHeartbeat writes a heartbeat message if nothing is written to the output file
+// during heartbeatInterval
+func (w *FSProxy) writeHeartbeat() error {
+	w.outputFileMutex.Lock()
+	defer w.outputFileMutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(w.lastOutputAt) < w.heartbeatInterval {
+		return nil
+	}
+	heartbeat, err := json.Marshal(heartbeatMessage{Type: "heartbeat", TS: now})
+	if err != nil {
+		return fmt.Errorf("marshal heartbeat: %w", err)
+	}
+	return w.writeResponseLocked([][]byte{heartbeat})
+}
+
+// writeResponseLocked is writeResponse which must be called with outputFileMutex locked
+func (w *FSProxy) writeResponseLocked(response [][]byte) error {
+	data := w.formatResponse(response)
+	if len(data) == 0 {
+		return nil
+	}
+	if w.outputRotationBytes > 0 && w.outputSize > 0 && w.outputSize+int64(len(data)) > w.outputRotationBytes {
+		if err := w.rotateOutput(); err != nil {
+			return fmt.Errorf("rotate output file: %w", err)
+		}
+		// Formatting depends on whether the array is open
+		data = w.formatResponse(response)
+	}
+	if w.maxOutputBytes > 0 && w.outputSize+int64(len(data)) > w.maxOutputBytes {
+		if w.outputLimitAction == DropOnOutputLimit {
+			w.logger.Warn("Drop response exceeding output file size limit", "maxOutputBytes", w.maxOutputBytes)
+			return nil
+		}
+		return ErrOutputLimit
+	}
+	if err := w.writeOutput(data); err != nil {
+		return err
+	}
+	if w.outputFormat == OutputJSONArray {
+		w.outputArrayOpen = true
+	}
+	return nil
+}
+
+// writeStream copies a response from r to the output file, terminating it with a newline.
+// The newline is written even if copying fails, so the next response starts on its own line
+func (w *FSProxy) writeStream(r io.Reader) (int64, error) {
+	w.outputFileMutex.Lock()
+	defer w.outputFileMutex.Unlock()
+
+	// The size of the response is unknown, so the file is rotated once it is exceeded
+	if w.outputRotationBytes > 0 && w.outputSize >= w.outputRotationBytes {
+		if err := w.rotateOutput(); err != nil {
+			return 0, fmt.Errorf("rotate output file: %w", err)
+		}
+	}
+	writer := &lastByteWriter{writer: w.outputFile}
+	written, copyErr := io.Copy(writer, r)
+	w.outputSize += written
+	if written == 0 {
+		return 0, copyErr
+	}
+	w.lastOutputAt = time.Now()
+	if writer.last != '\n' {
+		if err := w.writeOutput([]byte("\n")); err != nil && copyErr == nil {
+			copyErr = err
+		}
+	} else if w.syncOutput && copyErr == nil {
+		if err := w.outputFile.Sync(); err != nil {
+			copyErr = fmt.Errorf("sync output file: %w", err)
+		}
+	}
+	return written, copyErr
+}
+
+// lastByteWriter is a writer which remembers the last written byte
+type lastByteWriter struct {
+	writer io.Writer
+	last   byte
+}
+
+func (w *lastByteWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.last = p[n-1]
+	}
+	return n, err
+}
+
+// formatResponse returns the messages of response in the output format.
+// It must be called with outputFileMutex locked
+func (w *FSProxy) formatResponse(response [][]byte) []byte {
+	var buf bytes.Buffer
+	arrayOpen := w.outputArrayOpen
+	for _, message := range response {
+		message = compactMessage(message)
+		if len(message) == 0 {
+			continue
+		}
+		switch w.outputFormat {
+		case OutputJSONArray:
+			if arrayOpen {
+				buf.WriteString(",\n")
+			} else {
+				buf.WriteString("[\n")
+				arrayOpen = true
+			}
+			buf.Write(message)
+		case OutputFramed:
+			fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(message))
+			buf.Write(message)
+		default:
+			buf.Write(message)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes()
+}
+
+// compactMessage returns message without insignificant whitespace, so a pretty printed
+// message takes a single line. Message which is not valid JSON is only trimmed of trailing newlines
+func compactMessage(message []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, message); err != nil {
+		return bytes.TrimRight(message, "\r\n")
+	}
+	return buf.Bytes()
+}
+
+// closeOutputArray writes the closing bracket of the array if it is open.
+// It must be called with outputFileMutex locked
+func (w *FSProxy) closeOutputArray() error {
+	if !w.outputArrayOpen {
+		return nil
+	}
+	if err := w.writeOutput([]byte("\n]\n")); err != nil {
+		return err
+	}
+	w.outputArrayOpen = false
+	return nil
+}
+
+// writeOutput writes data to the output file.
+// It must be called with outputFileMutex locked
+func (w *FSProxy) writeOutput(data []byte) error {
+	n, err := w.outputFile.Write(data)
+	w.outputSize += int64(n)
+	w.lastOutputAt = time.Now()
+	if err != nil {
+		return err
+	}
+	if w.syncOutput {
+		if err := w.outputFile.Sync(); err != nil {
+			return fmt.Errorf("sync output file: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotateOutput renames the output file adding a timestamp suffix and opens a new one.
+// It must be called with outputFileMutex locked
+func (w *FSProxy) rotateOutput() error {
+	if err := w.closeOutputArray(); err != nil {
+		return fmt.Errorf("close output array: %w", err)
+	}
+	if err := w.outputFile.Close(); err != nil {
+		return fmt.Errorf("close output file: %w", err)
+	}
+	rotatedPath := w.outputFilePath + "." + time.Now().Format("20060102T150405.000000000")
+	renameErr := os.Rename(w.outputFilePath, rotatedPath)
+
+	// Reopen the output file even if renaming failed so writing can go on
+	outputFile, err := openFile(w.outputFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, w.filePerm)
+	if err != nil {
+		return fmt.Errorf("open output file: %w", err)
+	}
+	w.outputFile = w.bufferOutput(outputFile)
+	if renameErr != nil {
+		return fmt.Errorf("rename output file: %w", renameErr)
+	}
+	w.outputSize = 0
+	w.logger.Info("Output file rotated", "path", rotatedPath)
+	return nil
+}
@@ -0,0 +1,394 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFSProxyWritesResponsePerLine(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithOrderedOutput()).start()
+
+	p.write(rpcRequest(1, "first"), rpcRequest(2, "second"))
+	p.waitLines(2)
+
+	want := rpcResult(1, "first") + "\n" + rpcResult(2, "second") + "\n"
+	if output := p.output(); output != want {
+		t.Errorf("output = %q, want %q", output, want)
+	}
+}
+
+func TestFSProxyCompactsPrettyResponse(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{\n  \"jsonrpc\": \"2.0\",\n  \"id\": 1,\n  \"result\": \"ping\"\n}\n"))
+	})
+	p := newTestProxy(t, server.URL).start()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	if want := rpcResult(1, "ping") + "\n"; p.output() != want {
+		t.Errorf("output = %q, want %q", p.output(), want)
+	}
+}
+
+// syncCountingOutput is outputWriter which counts calls of Sync
+type syncCountingOutput struct {
+	outputWriter
+	syncs int32
+}
+
+func (o *syncCountingOutput) Sync() error {
+	atomic.AddInt32(&o.syncs, 1)
+	return o.outputWriter.Sync()
+}
+
+func TestFSProxySyncOutput(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithSyncOutput())
+	output := &syncCountingOutput{outputWriter: p.outputFile}
+	p.outputFile = output
+	p.start()
+
+	p.write(rpcRequest(1, "first"), rpcRequest(2, "second"))
+	p.waitLines(2)
+
+	if syncs := atomic.LoadInt32(&output.syncs); syncs != 2 {
+		t.Errorf("syncs = %d, want one per response", syncs)
+	}
+}
+
+func TestFSProxyOutputRotation(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	// Every response after the first one exceeds the size, so each is written to a new file
+	maxBytes := int64(len(rpcResult(1, "a")) + 1)
+	p := newTestProxy(t, server.URL, WithOutputRotation(maxBytes), WithMaxConcurrency(1)).start()
+
+	p.write(rpcRequest(1, "a"), rpcRequest(2, "b"), rpcRequest(3, "c"))
+	var rotated []string
+	eventually(t, func() bool {
+		var err error
+		if rotated, err = filepath.Glob(p.outputPath + ".*"); err != nil {
+			t.Fatalf("glob: %v", err)
+		}
+		return len(rotated) == 2 && p.output() != ""
+	}, "2 rotated files")
+
+	sort.Strings(rotated)
+	want := []string{rpcResult(1, "a"), rpcResult(2, "b")}
+	for i, path := range rotated {
+		if content := readFile(t, path); content != want[i]+"\n" {
+			t.Errorf("rotated file %d = %q, want %q", i, content, want[i])
+		}
+	}
+	if output := p.output(); output != rpcResult(3, "c")+"\n" {
+		t.Errorf("output = %q, want the last response", output)
+	}
+}
+
+func TestStreamProxyOutputFormats(t *testing.T) {
+	// Pretty printed responses are written compacted in every format
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		response, err := echoResponse(body)
+		if err != nil {
+			return
+		}
+		var pretty bytes.Buffer
+		_ = json.Indent(&pretty, response, "", "  ")
+		_, _ = w.Write(pretty.Bytes())
+	})
+	first, second := rpcResult(1, "a"), rpcResult(2, "b")
+	tests := []struct {
+		name   string
+		format OutputFormat
+		want   string
+	}{
+		{name: "ndjson", format: OutputNDJSON, want: first + "\n" + second + "\n"},
+		{name: "json array", format: OutputJSONArray, want: "[\n" + first + ",\n" + second + "\n]\n"},
+		{
+			name:   "framed",
+			format: OutputFramed,
+			want: fmt.Sprintf("Content-Length: %d\r\n\r\n%sContent-Length: %d\r\n\r\n%s",
+				len(first), first, len(second), second),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var output bytes.Buffer
+			input := strings.NewReader(rpcRequest(1, "a") + "\n" + rpcRequest(2, "b") + "\n")
+			proxy, err := NewStreamProxy(server.URL, input, &output, nil, WithOutputFormat(tt.format), WithOrderedOutput())
+			if err != nil {
+				t.Fatalf("new proxy: %v", err)
+			}
+			if err := proxy.Run(context.Background()); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			// The array is closed on Close
+			if err := proxy.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+
+			if output.String() != tt.want {
+				t.Errorf("output = %q, want %q", output.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFSProxyMaxOutputBytes(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	// Only the first response fits
+	maxBytes := int64(len(rpcResult(1, "a")) + 1)
+
+	t.Run("fail", func(t *testing.T) {
+		p := newTestProxy(t, server.URL, WithMaxOutputBytes(maxBytes, FailOnOutputLimit), WithMaxConcurrency(1)).start()
+		p.write(rpcRequest(1, "a"), rpcRequest(2, "b"))
+
+		if err := p.wait(); !errors.Is(err, ErrOutputLimit) {
+			t.Errorf("run error = %v, want %v", err, ErrOutputLimit)
+		}
+		if output := p.output(); output != rpcResult(1, "a")+"\n" {
+			t.Errorf("output = %q, want the first response", output)
+		}
+	})
+	t.Run("drop", func(t *testing.T) {
+		p := newTestProxy(t, server.URL, WithMaxOutputBytes(maxBytes, DropOnOutputLimit), WithMaxConcurrency(1)).start()
+		p.write(rpcRequest(1, "a"), rpcRequest(2, "b"))
+		eventually(t, func() bool {
+			return p.Stats().Processed == 2
+		}, "2 proxied lines")
+
+		if output := p.output(); output != rpcResult(1, "a")+"\n" {
+			t.Errorf("output = %q, want the first response", output)
+		}
+	})
+}
+
+func TestFSProxyTruncateOutput(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{name: "append", want: "previous run\n" + rpcResult(1, "ping") + "\n"},
+		{name: "truncate", opts: []Option{WithTruncateOutput()}, want: rpcResult(1, "ping") + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			outputPath := filepath.Join(dir, "output")
+			appendFile(t, outputPath, "previous run\n")
+			p := newTestProxyAt(t, server.URL, filepath.Join(dir, "input"), outputPath,
+				append([]Option{WithReadExisting()}, tt.opts...)...).start()
+
+			p.write(rpcRequest(1, "ping"))
+			eventually(t, func() bool {
+				return p.Stats().Processed == 1
+			}, "line to be proxied")
+
+			if output := p.output(); output != tt.want {
+				t.Errorf("output = %q, want %q", output, tt.want)
+			}
+		})
+	}
+}
+
+func TestFSProxyOutputNamer(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	namer := func(request []byte) (string, error) {
+		var r struct {
+			ID json.RawMessage `json:"id"`
+		}
+		if err := json.Unmarshal(request, &r); err != nil {
+			return "", err
+		}
+		return filepath.Join("responses", string(r.ID)+".json"), nil
+	}
+	p := newTestProxy(t, server.URL, WithOutputNamer(namer)).start()
+
+	p.write(rpcRequest(1, "first"), rpcRequest(2, "second"))
+	eventually(t, func() bool {
+		return p.Stats().Processed == 2
+	}, "both lines to be proxied")
+
+	// Relative names are resolved against the directory of the output file
+	for id, want := range map[string]string{"1": rpcResult(1, "first"), "2": rpcResult(2, "second")} {
+		path := filepath.Join(p.dir, "responses", id+".json")
+		if got := readFile(t, path); got != want+"\n" {
+			t.Errorf("%s = %q, want %q", path, got, want+"\n")
+		}
+	}
+	if output := p.output(); output != "" {
+		t.Errorf("output = %q, want empty", output)
+	}
+}
+
+func TestFSProxyOutputNamerError(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	errName := errors.New("no id")
+	namer := func([]byte) (string, error) {
+		return "", errName
+	}
+	p := newTestProxy(t, server.URL, WithOutputNamer(namer)).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	// The line fails, the proxy keeps running
+	if lineErr := p.waitLineError(); !errors.Is(lineErr, errName) {
+		t.Errorf("error = %v, want %v", lineErr, errName)
+	}
+}
+
+func TestFSProxyHeartbeat(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithHeartbeat(20*time.Millisecond)).start()
+
+	p.write(rpcRequest(1, "ping"))
+	lines := p.waitLines(3)
+
+	var heartbeats int
+	for _, line := range lines {
+		if line == rpcResult(1, "ping") {
+			continue
+		}
+		var heartbeat struct {
+			Type string    `json:"type"`
+			TS   time.Time `json:"ts"`
+		}
+		if err := json.Unmarshal([]byte(line), &heartbeat); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		if heartbeat.Type != "heartbeat" || heartbeat.TS.IsZero() {
+			t.Errorf("heartbeat = %q, want type and ts", line)
+		}
+		heartbeats++
+	}
+	if heartbeats < 2 {
+		t.Errorf("heartbeats = %d, want at least 2 in %q", heartbeats, lines)
+	}
+}
+
+func TestFSProxyStreamingResponses(t *testing.T) {
+	const resultBytes = 16 << 20
+	chunk := bytes.Repeat([]byte("a"), 32<<10)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if !bufferBody(r) {
+			return
+		}
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":"`)
+		for written := 0; written < resultBytes; written += len(chunk) {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+		_, _ = io.WriteString(w, `"}`)
+	})
+	logger := &recordingLogger{}
+	p := newLoggedTestProxy(t, server.URL, logger, WithStreamingResponses()).start()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	p.write(rpcRequest(1, "large"))
+	eventually(t, func() bool {
+		return p.Stats().Processed == 1
+	}, "line to be proxied")
+	runtime.ReadMemStats(&after)
+
+	// The response is not read into memory as a whole
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > resultBytes/2 {
+		t.Errorf("allocated %d bytes for a response of %d bytes", allocated, resultBytes)
+	}
+	if len(logger.find("Got streamed response")) != 1 {
+		t.Error("response is not streamed")
+	}
+	output := p.output()
+	want := `{"jsonrpc":"2.0","id":1,"result":"` + strings.Repeat("a", resultBytes) + `"}` + "\n"
+	if output != want {
+		t.Errorf("output of %d bytes does not match the response of %d bytes", len(output), len(want))
+	}
+}
+
+func TestFSProxyBufferedOutput(t *testing.T) {
+	const lines = 10
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithBufferedOutput(1<<20, 0)).start()
+
+	for i := 0; i < lines; i++ {
+		p.write(rpcRequest(i, "ping"))
+	}
+	eventually(t, func() bool {
+		return p.Stats().Processed == lines
+	}, "%d proxied lines", lines)
+	if output := p.output(); output != "" {
+		t.Errorf("output before the buffer is full = %q, want empty", output)
+	}
+
+	// The buffer is written when Run returns
+	if err := p.stop(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if got := len(splitLines(p.output())); got != lines {
+		t.Errorf("output lines = %d, want %d", got, lines)
+	}
+}
+
+func TestFSProxyBufferedOutputFlushInterval(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithBufferedOutput(1<<20, 20*time.Millisecond)).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+}
+
+func BenchmarkFSProxyWriteResponse(b *testing.B) {
+	response := [][]byte{[]byte(rpcResult(1, "ping"))}
+	benchmarks := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "unbuffered"},
+		{name: "buffered", opts: []Option{WithBufferedOutput(64<<10, 0)}},
+	}
+	for _, bb := range benchmarks {
+		b.Run(bb.name, func(b *testing.B) {
+			dir := b.TempDir()
+			p, err := NewFSProxy("http://localhost", filepath.Join(dir, "input"), filepath.Join(dir, "output"), nil, bb.opts...)
+			if err != nil {
+				b.Fatalf("new proxy: %v", err)
+			}
+			defer p.Close()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := p.writeResponse(response); err != nil {
+					b.Fatalf("write response: %v", err)
+				}
+			}
+			if err := p.flushOutput(); err != nil {
+				b.Fatalf("flush: %v", err)
+			}
+		})
+	}
+}
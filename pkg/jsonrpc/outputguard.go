@@ -0,0 +1,141 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrOutputUnwritable is the failure reason of lines not sent because responses
+// could not be written to the output file
+var ErrOutputUnwritable = errors.New("output file is unwritable")
+
+// OutputErrorAction defines what happens to lines while the output file is unwritable
+type OutputErrorAction int
+
+const (
+	// PauseOnOutputErrors makes lines wait until the output file is writable again
+	PauseOnOutputErrors OutputErrorAction = iota
+	// DropOnOutputErrors makes lines be dropped without being sent
+	DropOnOutputErrors
+)
+
+// outputGuard holds lines for retryInterval after threshold consecutive failures
+// to write responses, then lets a single probe line through to check whether
+// the output file is writable again
+type outputGuard struct {
+	mu            sync.Mutex
+	threshold     int
+	retryInterval time.Duration
+	failures      int
+	lastErr       error
+	failedAt      time.Time
+}
+
+func newOutputGuard(threshold int, retryInterval time.Duration) *outputGuard {
+	return &outputGuard{
+		threshold:     threshold,
+		retryInterval: retryInterval,
+	}
+}
+
+// hold returns how long lines must be held and the reason, zero if a line may be sent.
+// It is always zero for nil guard
+func (g *outputGuard) hold() (time.Duration, error) {
+	if g == nil {
+		return 0, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failures < g.threshold {
+		return 0, nil
+	}
+	if wait := g.retryInterval - time.Since(g.failedAt); wait > 0 {
+		return wait, g.errLocked()
+	}
+	// The probe line holds the next ones for another interval
+	g.failedAt = time.Now()
+	return 0, nil
+}
+
+// record updates the guard with the result of writing a response and reports
+// whether the output file became unwritable or writable again
+func (g *outputGuard) record(err error) (unwritable, recovered bool) {
+	if g == nil {
+		return false, false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err == nil {
+		recovered = g.failures >= g.threshold
+		g.failures = 0
+		g.lastErr = nil
+		return false, recovered
+	}
+	g.failures++
+	g.lastErr = err
+	g.failedAt = time.Now()
+	return g.failures == g.threshold, false
+}
+
+// err returns the reason the output file is unwritable, nil if it is writable or guard is nil
+func (g *outputGuard) err() error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.failures < g.threshold {
+		return nil
+	}
+	return g.errLocked()
+}
+
+func (g *outputGuard) errLocked() error {
+	return fmt.Errorf("%w: %v", ErrOutputUnwritable, g.lastErr)
+}
+
+// holdUnwritable waits while the output file is unwritable with PauseOnOutputErrors.
+// With DropOnOutputErrors line is dropped instead. It reports whether line may be sent
+func (w *FSProxy) holdUnwritable(ctx context.Context, line string) bool {
+	for {
+		wait, err := w.outputGuard.hold()
+		if wait == 0 {
+			return true
+		}
+		if w.outputErrorAction == DropOnOutputErrors {
+			w.writeDeadLetter(ctx, line, err)
+			w.drop(line, dropOutput)
+			return false
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// recordOutput updates the output guard with the result of writing a response
+func (w *FSProxy) recordOutput(err error) {
+	if errors.Is(err, ErrOutputLimit) {
+		// The file is writable, so the limit is handled by WithMaxOutputBytes
+		return
+	}
+	unwritable, recovered := w.outputGuard.record(err)
+	switch {
+	case unwritable && w.outputErrorAction == DropOnOutputErrors:
+		w.logger.Error("Output file is unwritable, dropping lines", "failures", w.outputErrorLimit, "error", err)
+	case unwritable:
+		w.logger.Error("Output file is unwritable, pausing lines", "failures", w.outputErrorLimit, "error", err)
+	case recovered:
+		w.logger.Info("Output file is writable again")
+	}
+}
@@ -0,0 +1,103 @@
+package jsonrpc
+
+import (
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errDiskFull = errors.New("no space left on device")
+
+// failingOutput is outputWriter which fails writes while failing is set
+type failingOutput struct {
+	outputWriter
+	failing int32
+}
+
+func (o *failingOutput) Write(p []byte) (int, error) {
+	if atomic.LoadInt32(&o.failing) == 1 {
+		return 0, errDiskFull
+	}
+	return o.outputWriter.Write(p)
+}
+
+func (o *failingOutput) setFailing(failing bool) {
+	var value int32
+	if failing {
+		value = 1
+	}
+	atomic.StoreInt32(&o.failing, value)
+}
+
+// useFailingOutput makes responses of p be written to failingOutput wrapping the output file
+func useFailingOutput(p *testProxy) *failingOutput {
+	output := &failingOutput{outputWriter: p.outputFile, failing: 1}
+	p.outputFile = output
+	return output
+}
+
+func TestFSProxyOutputErrorsPause(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL,
+		WithOutputErrorLimit(2, 500*time.Millisecond, PauseOnOutputErrors),
+		WithMaxConcurrency(1),
+	)
+	output := useFailingOutput(p)
+	p.start()
+
+	p.write(rpcRequest(1, "first"), rpcRequest(2, "second"))
+	for i := 0; i < 2; i++ {
+		if lineErr := p.waitLineError(); !errors.Is(lineErr, errDiskFull) {
+			t.Fatalf("error = %v, want %v", lineErr, errDiskFull)
+		}
+	}
+	if code := probe(p.HealthHandler(), "/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("readiness while output is unwritable = %d, want 503", code)
+	}
+
+	p.write(rpcRequest(3, "paused"))
+	time.Sleep(100 * time.Millisecond)
+	if sent := len(recorder.received()); sent != 2 {
+		t.Errorf("sent requests while paused = %d, want 2", sent)
+	}
+
+	// After the retry interval the paused line probes the output file
+	output.setFailing(false)
+	if lines := p.waitLines(1); lines[0] != rpcResult(3, "paused") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(3, "paused"))
+	}
+	eventually(t, func() bool {
+		return probe(p.HealthHandler(), "/readyz") == http.StatusOK
+	}, "readiness after recovery")
+}
+
+func TestFSProxyOutputErrorsDrop(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL,
+		WithOutputErrorLimit(1, time.Hour, DropOnOutputErrors),
+		WithMaxConcurrency(1),
+	)
+	useFailingOutput(p)
+	p.start()
+
+	p.write(rpcRequest(1, "failed"))
+	if lineErr := p.waitLineError(); !errors.Is(lineErr, errDiskFull) {
+		t.Fatalf("error = %v, want %v", lineErr, errDiskFull)
+	}
+	p.write(rpcRequest(2, "dropped"))
+	lineErr := p.waitLineError()
+
+	if !errors.Is(lineErr, ErrLineDropped) || lineErr.Line != rpcRequest(2, "dropped") {
+		t.Errorf("error = %q: %v, want dropped line", lineErr.Line, lineErr)
+	}
+	if dropped := p.Stats().Dropped[dropOutput]; dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+	if sent := len(recorder.received()); sent != 1 {
+		t.Errorf("sent requests = %d, want 1", sent)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build !windows
+// +build !windows
+
+package jsonrpc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFSProxyPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	deadLetterPath := filepath.Join(dir, "dead-letter")
+	// Permissions without group and other bits are not changed by a usual umask
+	p := newTestProxyAt(t, "http://localhost", filepath.Join(dir, "input"), filepath.Join(dir, "output"),
+		WithFilePerm(0600), WithDirPerm(0700), WithDeadLetterFile(deadLetterPath))
+
+	for path, want := range map[string]os.FileMode{
+		dir:            os.ModeDir | 0700,
+		p.inputPath:    0600,
+		p.outputPath:   0600,
+		deadLetterPath: 0600,
+	} {
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("stat %s: %v", path, err)
+		}
+		if mode := stat.Mode(); mode != want {
+			t.Errorf("%s mode = %v, want %v", path, mode, want)
+		}
+	}
+}
+
+func TestNewFSProxyKeepsExistingPermissions(t *testing.T) {
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "output")
+	appendFile(t, outputPath, "")
+	if err := os.Chmod(outputPath, 0640); err != nil {
+		t.Fatalf("chmod: %v", err)
+	}
+
+	newTestProxyAt(t, "http://localhost", filepath.Join(dir, "input"), outputPath, WithFilePerm(0600))
+
+	stat, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if mode := stat.Mode(); mode != 0640 {
+		t.Errorf("mode = %v, want %v", mode, os.FileMode(0640))
+	}
+}
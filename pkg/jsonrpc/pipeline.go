@@ -0,0 +1,467 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// processLines proxies lines of lineStream until it is closed or ctx is done.
+// In-flight requests are aborted when requestCtx is done. Every goroutine it starts
+// is added to wg, so waiting for wg after both contexts are done leaves none running
+func (w *FSProxy) processLines(
+	ctx, requestCtx context.Context,
+	wg *sync.WaitGroup,
+	lineStream <-chan inputLine,
+) {
+	var dedup *deduplicator
+	if w.dedupLines {
+		dedup = &deduplicator{window: w.dedupWindow}
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case line, ok := <-lineStream:
+				if !ok {
+					return
+				}
+				if !w.admitLine(ctx, dedup, line.text) {
+					if ctx.Err() != nil {
+						return
+					}
+					continue
+				}
+				if !w.dispatchLine(ctx, requestCtx, wg, line) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// admitLine reports whether line should be sent. Skipped lines are dropped, lines are held while
+// the output file is unwritable and the rate limit is waited for. It returns false if ctx is done
+func (w *FSProxy) admitLine(ctx context.Context, dedup *deduplicator, line string) bool {
+	if size := len(line); w.maxRequestBytes > 0 && size > w.maxRequestBytes {
+		w.logger.Warn("Skip line exceeding max request size", "bytes", size, "maxRequestBytes", w.maxRequestBytes)
+		w.writeDeadLetter(ctx, line, fmt.Errorf("request of %d bytes exceeds max size %d", size, w.maxRequestBytes))
+		w.drop(line, dropOversize)
+		return false
+	}
+	if w.validateInput && !json.Valid([]byte(line)) {
+		w.logger.Warn("Skip invalid JSON line", "line", line)
+		w.drop(line, dropInvalid)
+		return false
+	}
+	if w.validateJSONRPC {
+		if err := validateRequest([]byte(line), w.strictJSONRPC); err != nil {
+			w.logger.Warn("Skip invalid JSON-RPC line", "line", line, "error", err)
+			w.writeDeadLetter(ctx, line, err)
+			w.drop(line, dropInvalid)
+			return false
+		}
+	}
+	if w.methodFilter != nil && !w.allowMethods(line) {
+		w.logger.Info("Skip filtered line", "line", line)
+		w.drop(line, dropFiltered)
+		return false
+	}
+	if dedup != nil && dedup.duplicate(line, time.Now()) {
+		w.logger.Warn("Skip duplicate line", "line", line)
+		w.drop(line, dropDuplicate)
+		return false
+	}
+	if !w.holdUnwritable(ctx, line) {
+		return false
+	}
+	if w.limiter != nil {
+		return w.limiter.Wait(ctx) == nil
+	}
+	return true
+}
+
+// dispatchLine proxies line in a new goroutine once the concurrency limit allows it.
+// It returns false if ctx is done before
+func (w *FSProxy) dispatchLine(ctx, requestCtx context.Context, wg *sync.WaitGroup, line inputLine) bool {
+	// Semaphore is replaced by Reload, so the one acquired is released
+	semaphore := w.currentSettings().semaphore
+	if semaphore != nil {
+		select {
+		case <-ctx.Done():
+			return false
+		case semaphore <- struct{}{}:
+		}
+	}
+	seq := atomic.AddUint64(&w.seq, 1) - 1
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if semaphore != nil {
+			defer func() { <-semaphore }()
+		}
+		w.processLine(requestCtx, seq, line.text)
+		// Aborted lines are not committed, so they are read again after restart
+		if w.checkpoint != nil && requestCtx.Err() == nil {
+			w.checkpoint.done(seq, line.offset)
+		}
+	}()
+	return true
+}
+
+// drop records a line skipped for reason
+func (w *FSProxy) drop(line, reason string) {
+	w.stats.drop(reason)
+	w.metrics.observeDrop(reason)
+	w.reportLineError(line, fmt.Errorf("%w: %s", ErrLineDropped, reason))
+}
+
+// logDropped logs the number of skipped lines by reason, if any
+func (w *FSProxy) logDropped() {
+	dropped := w.stats.snapshot().Dropped
+	if len(dropped) == 0 {
+		return
+	}
+	reasons := make([]string, 0, len(dropped))
+	for reason := range dropped {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	kv := make([]interface{}, 0, 2*len(reasons))
+	for _, reason := range reasons {
+		kv = append(kv, reason, dropped[reason])
+	}
+	w.logger.Warn("Dropped lines", kv...)
+}
+
+// allowMethods reports whether methodFilter allows all methods of the line.
+// Lines without method are not allowed
+func (w *FSProxy) allowMethods(line string) bool {
+	methods, ok := requestMethods(w.requestParser, []byte(line))
+	if !ok {
+		return false
+	}
+	for _, method := range methods {
+		if !w.methodFilter(method) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *FSProxy) processLine(ctx context.Context, seq uint64, line string) {
+	w.stats.begin()
+	err := w.proxyLine(ctx, seq, line)
+	w.stats.end(err)
+	if err != nil {
+		w.reportLineError(line, err)
+	}
+}
+
+// proxyLine sends line to the RPC server and writes the response.
+// It returns error if the line could not be proxied
+func (w *FSProxy) proxyLine(ctx context.Context, seq uint64, line string) error {
+	s := w.currentSettings()
+	ctx, span := w.startSpan(ctx, s.rpcURL, line)
+	if w.correlationHeader != "" {
+		ctx = withCorrelationID(ctx, lineCorrelationID(w.requestParser, line))
+	}
+	logger := w.lineLogger(ctx)
+	// Original line is dead-lettered, so it is transformed again on replay
+	original := line
+	line, err := w.transformRequest(line)
+	// Server must not reply to notifications, so they are sent as usual to drop any reply
+	if err == nil && s.streamer != nil && !w.dryRun && !isNotification(w.requestParser, []byte(line)) {
+		return w.proxyStream(ctx, span, s, original, line)
+	}
+	var (
+		bodyBytes []byte
+		status    int
+		latency   time.Duration
+	)
+	if err == nil && w.dryRun {
+		rpcURLs := s.requestURLs(w.requestParser, w.methodRoutes, line)
+		logger.Info("Dry run, request is not sent", "request", line, "rpcURLs", rpcURLs)
+		bodyBytes = []byte(dryRunResponse)
+	} else if err == nil {
+		start := time.Now()
+		bodyBytes, status, err = w.sendWithRetry(ctx, s, line)
+		latency = time.Since(start)
+	}
+	endSpan(span, status, len(bodyBytes), err)
+
+	// Messages written to the output file, several if a batch response is split
+	var messages [][]byte
+	switch {
+	case err != nil:
+		logger.Error("Failed to send request", "error", err)
+		w.writeDeadLetter(ctx, original, err)
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.Body != nil {
+			messages = [][]byte{statusErr.Body}
+		}
+	case isNotification(w.requestParser, []byte(line)):
+		// Server must not reply to notifications, so nothing is written
+		logger.Info("Notification sent")
+	case len(bodyBytes) == 0:
+		// E.g. 202 Accepted or 204 No Content
+		logger.Info("Got empty response")
+	default:
+		logger.Info("Got response", "response", string(bodyBytes))
+		response, transformErr := w.transformResponse([]byte(line), bodyBytes)
+		if transformErr != nil {
+			err = transformErr
+			logger.Error("Failed to transform response", "error", err)
+			w.writeDeadLetter(ctx, original, err)
+			break
+		}
+		switch {
+		case w.splitBatchResponses && isBatch([]byte(line)):
+			messages = w.splitBatch(response)
+		case w.responseEnvelope:
+			messages = [][]byte{w.envelopeResponse([]byte(line), response, latency)}
+		case w.annotateResponseIDs && !isBatch([]byte(line)):
+			messages = [][]byte{w.annotateResponse([]byte(line), response)}
+		default:
+			messages = [][]byte{response}
+		}
+	}
+
+	var writeErr error
+	if w.outputNamer != nil {
+		// Each response goes to its own file, so there is nothing to order
+		writeErr = w.writeNamedResponse([]byte(original), messages)
+	} else {
+		writeErr = w.output(seq, messages)
+	}
+	w.recordOutput(writeErr)
+	if writeErr != nil {
+		logger.Error("Failed to write response", "error", writeErr)
+		if errors.Is(writeErr, ErrOutputLimit) {
+			w.errorStream <- writeErr
+		}
+		return fmt.Errorf("write response: %w", writeErr)
+	}
+	return err
+}
+
+// requestURLs returns URLs of the RPC server line may be sent to
+func (s *settings) requestURLs(parser RequestParser, routes []methodRoute, line string) []string {
+	if route := matchRoute(parser, routes, []byte(line)); route >= 0 {
+		return []string{routes[route].rpcURL}
+	}
+	return append([]string{s.rpcURL}, s.extraURLs...)
+}
+
+// proxyStream sends line to the RPC server and streams the response to the output file
+func (w *FSProxy) proxyStream(ctx context.Context, span trace.Span, s *settings, original, line string) error {
+	logger := w.lineLogger(ctx)
+	written, status, err := w.sendStreamWithRetry(ctx, s, line)
+	endSpan(span, status, int(written), err)
+	switch {
+	case err != nil:
+		logger.Error("Failed to send request", "error", err)
+		w.writeDeadLetter(ctx, original, err)
+	case written == 0:
+		logger.Info("Got empty response")
+	default:
+		logger.Info("Got streamed response", "bytes", written)
+	}
+	return err
+}
+
+// transformRequest applies request transforms to line
+func (w *FSProxy) transformRequest(line string) (string, error) {
+	if len(w.requestTransforms) == 0 {
+		return line, nil
+	}
+	request := []byte(line)
+	for _, transform := range w.requestTransforms {
+		var err error
+		if request, err = transform(request); err != nil {
+			return line, fmt.Errorf("transform request: %w", err)
+		}
+	}
+	return string(request), nil
+}
+
+// transformResponse applies response transforms to response of request
+func (w *FSProxy) transformResponse(request, response []byte) ([]byte, error) {
+	for _, transform := range w.responseTransforms {
+		var err error
+		if response, err = transform(request, response); err != nil {
+			return nil, fmt.Errorf("transform response: %w", err)
+		}
+	}
+	return response, nil
+}
+
+func (w *FSProxy) sendWithRetry(ctx context.Context, s *settings, line string) ([]byte, int, error) {
+	for attempt := 1; ; attempt++ {
+		response, status, err := w.send(ctx, s, line)
+		if err == nil || attempt >= s.retryPolicy.MaxAttempts || !isRetryable(err) {
+			return response, status, err
+		}
+		if err := w.waitRetry(ctx, s, attempt, err); err != nil {
+			return nil, status, err
+		}
+	}
+}
+
+// sendStreamWithRetry is sendWithRetry for streamed responses. Requests are not retried
+// once a part of the response is written
+func (w *FSProxy) sendStreamWithRetry(ctx context.Context, s *settings, line string) (int64, int, error) {
+	for attempt := 1; ; attempt++ {
+		written, status, err := w.sendStream(ctx, s, line)
+		if err == nil || written > 0 || attempt >= s.retryPolicy.MaxAttempts || !isRetryable(err) {
+			return written, status, err
+		}
+		if err := w.waitRetry(ctx, s, attempt, err); err != nil {
+			return 0, status, err
+		}
+	}
+}
+
+// waitRetry waits for the delay before the next attempt after err
+func (w *FSProxy) waitRetry(ctx context.Context, s *settings, attempt int, err error) error {
+	delay := s.retryPolicy.delayAfter(attempt, err)
+	w.lineLogger(ctx).Warn(
+		"Failed to send request, retrying",
+		"error", err,
+		"attempt", attempt,
+		"delay", delay,
+	)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// send sends line once and returns the response and its HTTP status, 0 if it is not known
+func (w *FSProxy) send(ctx context.Context, s *settings, line string) ([]byte, int, error) {
+	if !w.breaker.allow() {
+		return nil, 0, ErrCircuitOpen
+	}
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	var header http.Header
+	if w.headersFile != nil {
+		ctx = withHeaderCapture(ctx, &header)
+	}
+	start := time.Now()
+	response, status, err := sendWithStatus(ctx, s.sender, []byte(line))
+	latency := time.Since(start)
+	w.writeHeaders(line, status, header)
+	logger := w.lineLogger(ctx)
+	logger.Info("Request done", "status", status, "latency", latency, "bytes", len(response))
+	w.metrics.observeRequest(latency, err)
+	w.breaker.record(err != nil && isRetryable(err))
+	return response, status, err
+}
+
+func (w *FSProxy) sendStream(ctx context.Context, s *settings, line string) (int64, int, error) {
+	if !w.breaker.allow() {
+		return 0, 0, ErrCircuitOpen
+	}
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	var header http.Header
+	if w.headersFile != nil {
+		ctx = withHeaderCapture(ctx, &header)
+	}
+	start := time.Now()
+	written, status, err := s.streamer.sendStream(ctx, []byte(line), w.writeStream)
+	latency := time.Since(start)
+	w.writeHeaders(line, status, header)
+	logger := w.lineLogger(ctx)
+	logger.Info("Request done", "status", status, "latency", latency, "bytes", written)
+	w.metrics.observeRequest(latency, err)
+	w.breaker.record(err != nil && isRetryable(err))
+	return written, status, err
+}
+
+// writeHeaders writes header of the response to the request to the response headers file if it is set
+func (w *FSProxy) writeHeaders(line string, status int, header http.Header) {
+	if w.headersFile == nil || header == nil {
+		return
+	}
+	id, _ := parseID(w.requestParser, []byte(line))
+	if err := w.headersFile.write(id, status, header); err != nil {
+		w.logger.Error("Failed to write response headers", "error", err)
+	}
+}
+
+func (w *FSProxy) writeDeadLetter(ctx context.Context, line string, reason error) {
+	if w.deadLetter == nil {
+		return
+	}
+	if err := w.deadLetter.write(line, correlationIDFrom(ctx), reason); err != nil {
+		w.logger.Error("Failed to write dead letter", "error", err)
+	}
+}
+
+// annotateResponse wraps response into an object with id of the request
+// and warns if response id does not match it
+func (w *FSProxy) annotateResponse(request, response []byte) []byte {
+	id, ok := parseID(w.requestParser, request)
+	if !ok {
+		return response
+	}
+	if responseID, ok := requestID(response); ok && !bytes.Equal(id, responseID) {
+		w.logger.Warn("Response id does not match request id", "requestID", string(id), "responseID", string(responseID))
+	}
+
+	annotated, err := json.Marshal(annotatedResponse{ID: id, Response: response})
+	if err != nil {
+		w.logger.Warn("Failed to annotate response, writing as is", "error", err)
+		return response
+	}
+	return annotated
+}
+
+// envelopeResponse wraps response into an object with the time, id of the request and latency
+func (w *FSProxy) envelopeResponse(request, response []byte, latency time.Duration) []byte {
+	id, _ := parseID(w.requestParser, request)
+	envelope, err := json.Marshal(responseEnvelope{
+		TS:        time.Now(),
+		ID:        id,
+		LatencyMs: float64(latency) / float64(time.Millisecond),
+		Response:  response,
+	})
+	if err != nil {
+		w.logger.Warn("Failed to wrap response into envelope, writing as is", "error", err)
+		return response
+	}
+	return envelope
+}
+
+func (w *FSProxy) splitBatch(response []byte) [][]byte {
+	messages, err := splitBatchResponse(response)
+	if err != nil {
+		w.logger.Warn("Failed to split batch response, writing as is", "error", err)
+		return [][]byte{response}
+	}
+	return messages
+}
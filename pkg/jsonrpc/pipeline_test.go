@@ -0,0 +1,392 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestFSProxyRequestTimeout(t *testing.T) {
+	server := newRPCServer(t, sleepHandler(waitTimeout))
+	p := newTestProxy(t, server.URL, WithRequestTimeout(50*time.Millisecond)).start()
+
+	p.write(rpcRequest(1, "sleep"))
+	lineErr := p.waitLineError()
+
+	if !errors.Is(lineErr, context.DeadlineExceeded) {
+		t.Errorf("error = %v, want deadline exceeded", lineErr)
+	}
+	if lineErr.Line != rpcRequest(1, "sleep") {
+		t.Errorf("line = %q, want the request", lineErr.Line)
+	}
+	if output := p.output(); output != "" {
+		t.Errorf("output = %q, want empty", output)
+	}
+}
+
+func TestFSProxyRequestTimeoutDisabled(t *testing.T) {
+	server := newRPCServer(t, sleepHandler(100*time.Millisecond))
+	p := newTestProxy(t, server.URL, WithRequestTimeout(0)).start()
+
+	p.write(rpcRequest(1, "sleep"))
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "sleep") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "sleep"))
+	}
+}
+
+func TestFSProxyMaxConcurrency(t *testing.T) {
+	const maxConcurrency = 2
+	var inFlight, maxInFlight int32
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		echoHandler(w, r)
+	})
+	p := newTestProxy(t, server.URL, WithMaxConcurrency(maxConcurrency)).start()
+
+	lines := make([]string, 0, 10)
+	for i := 0; i < cap(lines); i++ {
+		lines = append(lines, rpcRequest(i, "ping"))
+	}
+	p.write(lines...)
+	p.waitLines(len(lines))
+
+	if max := atomic.LoadInt32(&maxInFlight); max > maxConcurrency {
+		t.Errorf("max requests in flight = %d, want at most %d", max, maxConcurrency)
+	}
+}
+
+func TestFSProxyInputValidation(t *testing.T) {
+	var requests int32
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		echoHandler(w, r)
+	})
+	p := newTestProxy(t, server.URL, WithInputValidation()).start()
+
+	p.write(`{"id":1,"method":`, rpcRequest(2, "valid"))
+	lines := p.waitLines(1)
+
+	if lines[0] != rpcResult(2, "valid") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(2, "valid"))
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+	if lineErr := p.waitLineError(); !errors.Is(lineErr, ErrLineDropped) || lineErr.Line != `{"id":1,"method":` {
+		t.Errorf("line error = %v of %q, want dropped invalid line", lineErr, lineErr.Line)
+	}
+	if dropped := p.Stats().Dropped[dropInvalid]; dropped != 1 {
+		t.Errorf("dropped invalid lines = %d, want 1", dropped)
+	}
+}
+
+// blockingHandler replies once release is closed, signalling on started when a request arrives
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bufferBody(r) {
+			return
+		}
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		echoHandler(w, r)
+	}
+}
+
+func TestFSProxyDrainOnShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := newRPCServer(t, blockingHandler(started, release))
+	p := newTestProxy(t, server.URL, WithDrainTimeout(waitTimeout)).start()
+
+	p.write(rpcRequest(1, "in-flight"))
+	<-started
+	p.cancel()
+	close(release)
+
+	if err := p.wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if want := rpcResult(1, "in-flight") + "\n"; p.output() != want {
+		t.Errorf("output = %q, want %q", p.output(), want)
+	}
+}
+
+func TestFSProxyDrainTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	server := newRPCServer(t, blockingHandler(started, nil))
+	p := newTestProxy(t, server.URL, WithDrainTimeout(50*time.Millisecond)).start()
+
+	p.write(rpcRequest(1, "in-flight"))
+	<-started
+
+	if err := p.stop(); !errors.Is(err, ErrDrainTimeout) {
+		t.Errorf("run error = %v, want %v", err, ErrDrainTimeout)
+	}
+	if output := p.output(); output != "" {
+		t.Errorf("output = %q, want empty", output)
+	}
+}
+
+func TestFSProxyRateLimit(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithRateLimit(20, 1)).start()
+
+	start := time.Now()
+	p.write(rpcRequest(1, "a"), rpcRequest(2, "b"), rpcRequest(3, "c"), rpcRequest(4, "d"), rpcRequest(5, "e"))
+	p.waitLines(5)
+
+	// The first request is sent at once and each next one after 50ms
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("5 requests took %v, want at least 200ms", elapsed)
+	}
+}
+
+func TestFSProxyCancelAbortsRequest(t *testing.T) {
+	started := make(chan struct{}, 1)
+	aborted := make(chan struct{})
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if !bufferBody(r) {
+			return
+		}
+		started <- struct{}{}
+		<-r.Context().Done()
+		close(aborted)
+	})
+	p := newTestProxy(t, server.URL).start()
+
+	p.write(rpcRequest(1, "in-flight"))
+	<-started
+
+	if err := p.stop(); err != nil {
+		t.Errorf("run: %v", err)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(waitTimeout):
+		t.Fatal("request is not aborted")
+	}
+	if output := p.output(); output != "" {
+		t.Errorf("output = %q, want empty", output)
+	}
+}
+
+func TestFSProxyBackpressure(t *testing.T) {
+	const maxConcurrency = 2
+	started := make(chan struct{}, 1000)
+	release := make(chan struct{})
+	server := newRPCServer(t, blockingHandler(started, release))
+	p := newTestProxy(t, server.URL, WithMaxConcurrency(maxConcurrency))
+	baseline := runtime.NumGoroutine()
+	p.start()
+
+	lines := make([]string, 0, 500)
+	for i := 0; i < cap(lines); i++ {
+		lines = append(lines, rpcRequest(i, "flood"))
+	}
+	p.write(lines...)
+	for i := 0; i < maxConcurrency; i++ {
+		<-started
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Reading pauses while the limit is reached, so goroutines do not grow with the number of lines
+	if goroutines := runtime.NumGoroutine() - baseline; goroutines > 50 {
+		t.Errorf("goroutines while saturated = %d more than before, want far less than %d lines",
+			goroutines, len(lines))
+	}
+	if inFlight := p.Stats().InFlight; inFlight != maxConcurrency {
+		t.Errorf("in flight = %d, want %d", inFlight, maxConcurrency)
+	}
+	close(release)
+	p.waitLines(len(lines))
+}
+
+func TestFSProxyCancelDoesNotLeakGoroutines(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	const lines = 20
+	var started int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !bufferBody(r) {
+			return
+		}
+		atomic.AddInt32(&started, 1)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	p := newTestProxy(t, server.URL, WithMaxConcurrency(lines)).start()
+
+	for i := 0; i < lines; i++ {
+		p.write(rpcRequest(i, "in-flight"))
+	}
+	eventually(t, func() bool {
+		return atomic.LoadInt32(&started) == lines
+	}, "%d in-flight requests", lines)
+
+	if err := p.stop(); err != nil {
+		t.Errorf("run: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	// Closing the server closes idle connections of the client as well
+	server.Close()
+}
+
+func TestFSProxyStop(t *testing.T) {
+	const lines = 5
+	var started int32
+	slow := sleepHandler(100 * time.Millisecond)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&started, 1)
+		slow(w, r)
+	})
+	p := newTestProxy(t, server.URL).start()
+	for i := 0; i < lines; i++ {
+		p.write(rpcRequest(i, "queued"))
+	}
+	eventually(t, func() bool {
+		return atomic.LoadInt32(&started) == lines
+	}, "%d in-flight requests", lines)
+
+	p.Stop()
+
+	// Responses to lines read before Stop are written before it returns
+	if got := len(splitLines(p.output())); got != lines {
+		t.Errorf("output lines = %d, want %d", got, lines)
+	}
+	if err := p.wait(); err != nil {
+		t.Errorf("run: %v", err)
+	}
+}
+
+func TestFSProxyStopBeforeRun(t *testing.T) {
+	p := newTestProxy(t, unreachableURL(t))
+
+	p.Stop()
+
+	if err := p.Run(context.Background()); err != nil {
+		t.Errorf("run after stop: %v", err)
+	}
+}
+
+func TestStreamProxyStopWhileReadBlocks(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	var output bytes.Buffer
+	stream, err := NewStreamProxy(server.URL, reader, &output, nil)
+	if err != nil {
+		t.Fatalf("new stream proxy: %v", err)
+	}
+	defer stream.Close()
+	p := wrapTestProxy(t, stream, "", "").start()
+
+	if _, err := io.WriteString(writer, rpcRequest(1, "ping")+"\n"); err != nil {
+		t.Fatalf("write input: %v", err)
+	}
+	eventually(t, func() bool {
+		return p.Stats().Processed == 1
+	}, "line to be proxied")
+
+	// Reading blocks until the writer writes or closes the pipe, which it does not
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(waitTimeout):
+		t.Fatal("Stop has not returned")
+	}
+	if err := p.wait(); err != nil {
+		t.Errorf("run: %v", err)
+	}
+	if want := rpcResult(1, "ping") + "\n"; output.String() != want {
+		t.Errorf("output = %q, want %q", output.String(), want)
+	}
+}
+
+func TestFSProxyDryRun(t *testing.T) {
+	var requests int32
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		echoHandler(w, r)
+	})
+	logger := &recordingLogger{}
+	p := newLoggedTestProxy(t, server.URL, logger, WithDryRun(), WithOrderedOutput()).start()
+
+	p.write(rpcRequest(1, "ping"), `{"jsonrpc":"2.0","method":"notify"}`, rpcRequest(2, "ping"))
+	lines := p.waitLines(2)
+	if err := p.stop(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 0 {
+		t.Errorf("HTTP requests = %d, want 0", got)
+	}
+	// Nothing is written for the notification
+	if want := []string{dryRunResponse, dryRunResponse}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+	logged := logger.find("Dry run, request is not sent")
+	if len(logged) != 3 {
+		t.Fatalf("dry run logs = %d, want 3", len(logged))
+	}
+	if urls := logged[0].value("rpcURLs"); !reflect.DeepEqual(urls, []string{server.URL}) {
+		t.Errorf("rpcURLs = %v, want [%s]", urls, server.URL)
+	}
+}
+
+func TestFSProxyMaxRequestBytes(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter")
+	small := rpcRequest(2, "small")
+	p := newTestProxy(t, server.URL, WithMaxRequestBytes(len(small)), WithDeadLetterFile(deadLetterPath)).start()
+
+	oversized := rpcRequest(1, strings.Repeat("a", len(small)))
+	p.write(oversized, small)
+	p.waitLines(1)
+
+	if got, want := recorder.received(), []string{small}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+	var record deadLetterRecord
+	if err := json.Unmarshal([]byte(readFile(t, deadLetterPath)), &record); err != nil {
+		t.Fatalf("unmarshal dead letter: %v", err)
+	}
+	if record.Payload != oversized {
+		t.Errorf("dead letter payload = %q, want %q", record.Payload, oversized)
+	}
+	if dropped := p.Stats().Dropped[dropOversize]; dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+}
@@ -0,0 +1,77 @@
+package jsonrpc
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// settings are the settings of sending requests which Reload changes.
+// They are not modified once created, so a line is sent with the same settings throughout
+type settings struct {
+	rpcURL         string
+	extraURLs      []string
+	sender         Sender
+	streamer       streamSender // sends requests with streamed responses, nil if they are buffered
+	requestTimeout time.Duration
+	retryPolicy    RetryPolicy
+	semaphore      chan struct{}
+}
+
+func (w *FSProxy) newSettings(rpcURL string, o *options) *settings {
+	s := &settings{
+		rpcURL:         rpcURL,
+		extraURLs:      o.extraURLs,
+		sender:         newSender(rpcURL, o),
+		requestTimeout: o.requestTimeout,
+		retryPolicy:    o.retryPolicy,
+	}
+	if o.streamResponses {
+		s.streamer = w.newStreamer(s.sender)
+	}
+	if o.maxConcurrency > 0 {
+		s.semaphore = make(chan struct{}, o.maxConcurrency)
+	}
+	return s
+}
+
+func (w *FSProxy) currentSettings() *settings {
+	w.settingsMu.RLock()
+	defer w.settingsMu.RUnlock()
+
+	return w.settings
+}
+
+// Reload changes RPC URLs, URL strategy, headers, request timeout, retry policy
+// and max concurrency to the ones of config while the proxy runs, e.g. on SIGHUP.
+// Unset fields keep the values the proxy was created with, other fields are ignored.
+// Lines being sent keep the previous settings and do not count towards the new max concurrency.
+// Input and output files are not reopened, so reading goes on from the same offset
+func (w *FSProxy) Reload(config Config) error {
+	if atomic.LoadInt32(&w.closed) == 1 {
+		return ErrClosed
+	}
+	if len(config.RPCURLs) == 0 {
+		return errors.New("no RPC URL in config")
+	}
+	configOpts, err := config.Options()
+	if err != nil {
+		return err
+	}
+
+	w.settingsMu.Lock()
+	defer w.settingsMu.Unlock()
+
+	o := w.options
+	o.extraURLs = nil
+	if len(config.Headers) > 0 {
+		// WithHeaders adds to the headers instead of replacing them
+		o.header = nil
+	}
+	for _, opt := range configOpts {
+		opt(&o)
+	}
+	w.settings = w.newSettings(config.RPCURLs[0], &o)
+	w.logger.Info("Config reloaded", "rpcURLs", config.RPCURLs)
+	return nil
+}
@@ -0,0 +1,62 @@
+package jsonrpc
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFSProxyReload(t *testing.T) {
+	before := &recordingServer{}
+	beforeServer := newRPCServer(t, before.handle)
+	after := &recordingServer{}
+	headers := make(chan http.Header, 1)
+	afterServer := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		after.handle(w, r)
+	})
+	p := newTestProxy(t, beforeServer.URL, WithHeaders(http.Header{"X-Api-Key": {"old"}})).start()
+	p.write(rpcRequest(1, "before"))
+	p.waitLines(1)
+
+	err := p.Reload(Config{RPCURLs: []string{afterServer.URL}, Headers: map[string]string{"X-Api-Key": "new"}})
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	p.write(rpcRequest(2, "after"))
+	lines := p.waitLines(2)
+
+	if got, want := before.received(), []string{rpcRequest(1, "before")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests before reload = %q, want %q", got, want)
+	}
+	if got, want := after.received(), []string{rpcRequest(2, "after")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests after reload = %q, want %q", got, want)
+	}
+	// Headers are replaced, not added to
+	if keys := (<-headers).Values("X-Api-Key"); !reflect.DeepEqual(keys, []string{"new"}) {
+		t.Errorf("X-Api-Key = %q, want [new]", keys)
+	}
+	// Reading goes on from the same offset
+	if lines[1] != rpcResult(2, "after") {
+		t.Errorf("output = %q, want %q", lines[1], rpcResult(2, "after"))
+	}
+}
+
+func TestFSProxyReloadErrors(t *testing.T) {
+	p := newTestProxy(t, "http://localhost")
+
+	if err := p.Reload(Config{}); err == nil {
+		t.Error("reload without URL: error is nil")
+	}
+	config := Config{RPCURLs: []string{"http://primary", "http://secondary"}, URLStrategy: "random"}
+	if err := p.Reload(config); err == nil {
+		t.Error("reload with invalid strategy: error is nil")
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := p.Reload(Config{RPCURLs: []string{"http://localhost"}}); !errors.Is(err, ErrClosed) {
+		t.Errorf("reload after close = %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,40 @@
+package jsonrpc
+
+import "sync"
+
+// reorderBuffer holds responses until all responses of preceding lines are written
+type reorderBuffer struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64][][]byte
+}
+
+func newReorderBuffer() *reorderBuffer {
+	return &reorderBuffer{
+		pending: make(map[uint64][][]byte),
+	}
+}
+
+// push adds response messages of the line with sequence number seq and writes
+// all responses which are ready to be written in order. Nil response is skipped
+func (b *reorderBuffer) push(seq uint64, response [][]byte, write func([][]byte) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending[seq] = response
+	var firstErr error
+	for {
+		response, ok := b.pending[b.next]
+		if !ok {
+			return firstErr
+		}
+		delete(b.pending, b.next)
+		b.next++
+		if response == nil {
+			continue
+		}
+		if err := write(response); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package jsonrpc
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFSProxyOrderedOutput(t *testing.T) {
+	release := make(chan struct{})
+	fastDone := make(chan struct{})
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		// The slow request is the first one, it is answered after the fast one
+		if string(body) == rpcRequest(1, "slow") {
+			<-release
+			_, _ = w.Write([]byte(rpcResult(1, "slow")))
+			return
+		}
+		defer close(fastDone)
+		_, _ = w.Write([]byte(rpcResult(2, "fast")))
+	})
+	p := newTestProxy(t, server.URL, WithOrderedOutput()).start()
+
+	p.write(rpcRequest(1, "slow"), rpcRequest(2, "fast"))
+	<-fastDone
+	close(release)
+	lines := p.waitLines(2)
+
+	want := []string{rpcResult(1, "slow"), rpcResult(2, "fast")}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
+
+func TestReorderBuffer(t *testing.T) {
+	buffer := newReorderBuffer()
+	var written []string
+	write := func(response [][]byte) error {
+		written = append(written, string(response[0]))
+		return nil
+	}
+
+	for _, seq := range []uint64{2, 1} {
+		if err := buffer.push(seq, [][]byte{{byte('0' + seq)}}, write); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+	if len(written) != 0 {
+		t.Fatalf("written before the first response = %q", written)
+	}
+	// Nil response of a skipped line does not hold the next ones
+	if err := buffer.push(0, nil, write); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if want := []string{"1", "2"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("written = %q, want %q", written, want)
+	}
+}
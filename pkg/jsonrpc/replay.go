@@ -0,0 +1,81 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Replay sends every line of the file at path through the same pipeline as new input lines
+// and returns when all of them are processed. Records of the dead-letter file are replayed
+// with their original payload
+func (w *FSProxy) Replay(ctx context.Context, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open replay file: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			w.logger.Warn("Failed to close replay file", "error", err)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	lineStream := make(chan inputLine)
+	payloadStream := make(chan inputLine)
+	go func() {
+		defer close(payloadStream)
+		for line := range lineStream {
+			select {
+			case <-ctx.Done():
+				return
+			case payloadStream <- inputLine{text: unwrapDeadLetter(line.text), offset: -1}:
+			}
+		}
+	}()
+	w.processLines(ctx, ctx, &wg, payloadStream)
+
+	waitStream := make(chan struct{})
+	var scanErr error
+	go func() {
+		defer close(waitStream)
+		_, scanErr = w.scanLines(ctx, nil, file, -1, lineStream)
+		close(lineStream)
+		wg.Wait()
+	}()
+	// Errors of the pipeline are consumed so it is not blocked
+	var pipelineErr error
+	for {
+		select {
+		case <-waitStream:
+			if scanErr != nil {
+				return fmt.Errorf("scan replay file: %w", scanErr)
+			}
+			if pipelineErr != nil {
+				return pipelineErr
+			}
+			return ctx.Err()
+		case err := <-w.errorStream:
+			if pipelineErr == nil {
+				pipelineErr = err
+			}
+		}
+	}
+}
+
+// unwrapDeadLetter returns the original payload if line is a dead-letter record
+func unwrapDeadLetter(line string) string {
+	var record struct {
+		Payload *string `json:"payload"`
+		Reason  *string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(line), &record); err != nil {
+		return line
+	}
+	if record.Payload == nil || record.Reason == nil {
+		return line
+	}
+	return *record.Payload
+}
@@ -0,0 +1,29 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFSProxyReplay(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithOrderedOutput())
+	record, err := json.Marshal(deadLetterRecord{Payload: rpcRequest(2, "dead-letter"), Reason: "failed"})
+	if err != nil {
+		t.Fatalf("marshal record: %v", err)
+	}
+	replayPath := filepath.Join(p.dir, "replay")
+	appendFile(t, replayPath, rpcRequest(1, "raw")+"\n"+string(record)+"\n")
+
+	if err := p.Replay(context.Background(), replayPath); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	want := []string{rpcResult(1, "raw"), rpcResult(2, "dead-letter")}
+	if lines := splitLines(p.output()); !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,192 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RequestParser extracts method and id of a request, e.g. of a JSON-RPC dialect which
+// places them differently. It is used by method filtering and routing, correlation ids
+// and annotation of responses. Batches are split into requests before being parsed
+type RequestParser interface {
+	// Method returns method of request, false if it has none
+	Method(request []byte) (string, bool)
+	// ID returns id of request, false if it is a notification
+	ID(request []byte) (json.RawMessage, bool)
+}
+
+// JSONRPCParser is RequestParser of JSON-RPC 2.0 requests, which is used by default
+type JSONRPCParser struct{}
+
+// Method returns method member of request
+func (JSONRPCParser) Method(request []byte) (string, bool) {
+	var message struct {
+		Method *string `json:"method"`
+	}
+	if err := json.Unmarshal(request, &message); err != nil || message.Method == nil {
+		return "", false
+	}
+	return *message.Method, true
+}
+
+// ID returns id member of request, which is null if it is set to null
+func (JSONRPCParser) ID(request []byte) (json.RawMessage, bool) {
+	return requestID(request)
+}
+
+// parseID returns id of request in payload, false if it is a notification or a batch
+func parseID(parser RequestParser, payload []byte) (json.RawMessage, bool) {
+	if isBatch(payload) {
+		return nil, false
+	}
+	return parser.ID(payload)
+}
+
+// isNotification reports whether payload is a notification, i.e. a request without id,
+// or a batch consisting of notifications only. Server does not reply to notifications
+func isNotification(parser RequestParser, payload []byte) bool {
+	if isBatch(payload) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(payload, &items); err != nil || len(items) == 0 {
+			return false
+		}
+		for _, item := range items {
+			if !isNotification(parser, item) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// Server replies to invalid JSON with an error
+	if !json.Valid(payload) {
+		return false
+	}
+	_, hasID := parser.ID(payload)
+	return !hasID
+}
+
+// requestID returns id of JSON-RPC request or response in payload
+func requestID(payload []byte) (json.RawMessage, bool) {
+	var message struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(payload, &message); err != nil || message.ID == nil {
+		return nil, false
+	}
+	return message.ID, true
+}
+
+// validateBatch returns error if payload is not a non-empty batch of JSON-RPC 2.0 requests
+func validateBatch(payload []byte, strict bool) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(payload, &items); err != nil {
+		return fmt.Errorf("unmarshal batch: %w", err)
+	}
+	if len(items) == 0 {
+		return errors.New("empty batch")
+	}
+	for i, item := range items {
+		if isBatch(item) {
+			return fmt.Errorf("request %d: nested batch", i)
+		}
+		if err := validateRequest(item, strict); err != nil {
+			return fmt.Errorf("request %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateRequest returns error if payload is not a JSON-RPC 2.0 request or a batch of them.
+// In strict mode id must be a string, a number or null, and params must be an object or an array
+func validateRequest(payload []byte, strict bool) error {
+	if isBatch(payload) {
+		return validateBatch(payload, strict)
+	}
+
+	var request struct {
+		JSONRPC *string         `json:"jsonrpc"`
+		Method  *string         `json:"method"`
+		ID      json.RawMessage `json:"id"`
+		Params  json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return fmt.Errorf("unmarshal request: %w", err)
+	}
+	if request.JSONRPC == nil || *request.JSONRPC != "2.0" {
+		return errors.New(`jsonrpc is not "2.0"`)
+	}
+	if request.Method == nil {
+		return errors.New("method is missing")
+	}
+	if !strict {
+		return nil
+	}
+	if request.ID != nil {
+		switch request.ID[0] {
+		case '"', 'n', '-', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		default:
+			return errors.New("id is not a string, a number or null")
+		}
+	}
+	if request.Params != nil && request.Params[0] != '{' && request.Params[0] != '[' {
+		return errors.New("params is not an object or an array")
+	}
+	return nil
+}
+
+// requestMethods returns methods of request or of all requests of a batch in payload
+func requestMethods(parser RequestParser, payload []byte) ([]string, bool) {
+	if !isBatch(payload) {
+		method, ok := parser.Method(payload)
+		if !ok {
+			return nil, false
+		}
+		return []string{method}, true
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(payload, &items); err != nil || len(items) == 0 {
+		return nil, false
+	}
+	methods := make([]string, 0, len(items))
+	for _, item := range items {
+		itemMethods, ok := requestMethods(parser, item)
+		if !ok || isBatch(item) {
+			return nil, false
+		}
+		methods = append(methods, itemMethods...)
+	}
+	return methods, true
+}
+
+// MethodFilter reports whether request calling method should be sent
+type MethodFilter func(method string) bool
+
+// RequestTransform returns modified request, e.g. with an injected field
+type RequestTransform func(request []byte) ([]byte, error)
+
+// ResponseTransform returns modified response of request, e.g. with redacted secrets
+type ResponseTransform func(request, response []byte) ([]byte, error)
+
+// responseEnvelope is a response written to the output file along with metadata of its request
+type responseEnvelope struct {
+	TS        time.Time       `json:"ts"`
+	ID        json.RawMessage `json:"id"`
+	LatencyMs float64         `json:"latencyMs"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// heartbeatMessage is written to the output file when the proxy is idle
+type heartbeatMessage struct {
+	Type string    `json:"type"`
+	TS   time.Time `json:"ts"`
+}
+
+// annotatedResponse is a response written to the output file along with id of its request
+type annotatedResponse struct {
+	ID       json.RawMessage `json:"id"`
+	Response json.RawMessage `json:"response"`
+}
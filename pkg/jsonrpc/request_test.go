@@ -0,0 +1,268 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFSProxyNotification(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	// Ordered output makes the response of the request wait until the notification is done
+	p := newTestProxy(t, server.URL, WithOrderedOutput()).start()
+
+	p.write(`{"jsonrpc":"2.0","method":"notify"}`, rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	if want := rpcResult(1, "ping") + "\n"; p.output() != want {
+		t.Errorf("output = %q, want %q", p.output(), want)
+	}
+	if requests := recorder.received(); len(requests) != 2 {
+		t.Errorf("requests = %d, want 2", len(requests))
+	}
+}
+
+func TestIsNotification(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{payload: `{"jsonrpc":"2.0","method":"notify"}`, want: true},
+		{payload: `{"jsonrpc":"2.0","method":"call","id":1}`, want: false},
+		{payload: `{"jsonrpc":"2.0","method":"call","id":null}`, want: false},
+		{payload: `[{"jsonrpc":"2.0","method":"notify"}]`, want: true},
+		{payload: `[{"jsonrpc":"2.0","method":"notify"},{"jsonrpc":"2.0","method":"call","id":1}]`, want: false},
+		{payload: `not json`, want: false},
+	}
+	for _, tt := range tests {
+		if got := isNotification(JSONRPCParser{}, []byte(tt.payload)); got != tt.want {
+			t.Errorf("isNotification(%s) = %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestFSProxyResponseIDAnnotation(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":7,"result":"other"}`))
+	})
+	logger := &recordingLogger{}
+	p := newLoggedTestProxy(t, server.URL, logger, WithResponseIDAnnotation()).start()
+
+	p.write(rpcRequest(1, "ping"))
+	lines := p.waitLines(1)
+
+	if want := `{"id":1,"response":{"jsonrpc":"2.0","id":7,"result":"other"}}`; lines[0] != want {
+		t.Errorf("output = %q, want %q", lines[0], want)
+	}
+	warnings := logger.find("Response id does not match request id")
+	if len(warnings) != 1 {
+		t.Fatalf("mismatch warnings = %d, want 1", len(warnings))
+	}
+	if id := warnings[0].value("responseID"); id != "7" {
+		t.Errorf("responseID = %v, want 7", id)
+	}
+}
+
+func TestFSProxyResponseEnvelope(t *testing.T) {
+	server := newRPCServer(t, sleepHandler(10*time.Millisecond))
+	p := newTestProxy(t, server.URL, WithResponseEnvelope()).start()
+	before := time.Now()
+
+	p.write(rpcRequest(1, "ping"))
+	lines := p.waitLines(1)
+
+	var envelope struct {
+		TS        time.Time       `json:"ts"`
+		ID        json.RawMessage `json:"id"`
+		LatencyMs *float64        `json:"latencyMs"`
+		Response  json.RawMessage `json:"response"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &envelope); err != nil {
+		t.Fatalf("unmarshal %q: %v", lines[0], err)
+	}
+	if envelope.TS.Before(before.Truncate(time.Second)) || envelope.TS.After(time.Now()) {
+		t.Errorf("ts = %v, want the time of the response", envelope.TS)
+	}
+	if string(envelope.ID) != "1" {
+		t.Errorf("id = %s, want 1", envelope.ID)
+	}
+	if envelope.LatencyMs == nil || *envelope.LatencyMs < 0 {
+		t.Errorf("latencyMs = %v, want non-negative latency", envelope.LatencyMs)
+	}
+	if string(envelope.Response) != rpcResult(1, "ping") {
+		t.Errorf("response = %s, want %s", envelope.Response, rpcResult(1, "ping"))
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name           string
+		payload        string
+		wantLenientErr bool
+		wantStrictErr  bool
+	}{
+		{name: "valid", payload: `{"jsonrpc":"2.0","id":1,"method":"ping","params":[]}`},
+		{name: "notification", payload: `{"jsonrpc":"2.0","method":"ping"}`},
+		{name: "string id", payload: `{"jsonrpc":"2.0","id":"a","method":"ping","params":{}}`},
+		{name: "null id", payload: `{"jsonrpc":"2.0","id":null,"method":"ping"}`},
+		{name: "no version", payload: `{"id":1,"method":"ping"}`, wantLenientErr: true, wantStrictErr: true},
+		{name: "old version", payload: `{"jsonrpc":"1.0","id":1,"method":"ping"}`, wantLenientErr: true, wantStrictErr: true},
+		{name: "no method", payload: `{"jsonrpc":"2.0","id":1}`, wantLenientErr: true, wantStrictErr: true},
+		{name: "not json", payload: `ping`, wantLenientErr: true, wantStrictErr: true},
+		{name: "object id", payload: `{"jsonrpc":"2.0","id":{},"method":"ping"}`, wantStrictErr: true},
+		{name: "scalar params", payload: `{"jsonrpc":"2.0","id":1,"method":"ping","params":1}`, wantStrictErr: true},
+		{name: "batch", payload: `[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","method":"b"}]`},
+		{name: "empty batch", payload: `[]`, wantLenientErr: true, wantStrictErr: true},
+		{
+			name:           "nested batch",
+			payload:        `[[{"jsonrpc":"2.0","id":1,"method":"a"}]]`,
+			wantLenientErr: true,
+			wantStrictErr:  true,
+		},
+		{
+			name:           "invalid batch item",
+			payload:        `[{"jsonrpc":"2.0","id":1,"method":"a"},{"id":2}]`,
+			wantLenientErr: true,
+			wantStrictErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		if err := validateRequest([]byte(tt.payload), false); (err != nil) != tt.wantLenientErr {
+			t.Errorf("%s: lenient error = %v, want error %v", tt.name, err, tt.wantLenientErr)
+		}
+		if err := validateRequest([]byte(tt.payload), true); (err != nil) != tt.wantStrictErr {
+			t.Errorf("%s: strict error = %v, want error %v", tt.name, err, tt.wantStrictErr)
+		}
+	}
+}
+
+func TestFSProxyJSONRPCValidation(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	deadLetterPath := filepath.Join(t.TempDir(), "dead-letter")
+	p := newTestProxy(t, server.URL, WithJSONRPCValidation(true), WithDeadLetterFile(deadLetterPath)).start()
+
+	invalid := `{"jsonrpc":"2.0","id":{},"method":"ping"}`
+	p.write(invalid, rpcRequest(2, "ping"))
+	p.waitLines(1)
+
+	if got, want := recorder.received(), []string{rpcRequest(2, "ping")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+	var record deadLetterRecord
+	if err := json.Unmarshal([]byte(readFile(t, deadLetterPath)), &record); err != nil {
+		t.Fatalf("unmarshal dead letter: %v", err)
+	}
+	if record.Payload != invalid {
+		t.Errorf("dead letter payload = %q, want %q", record.Payload, invalid)
+	}
+}
+
+func TestJSONRPCParser(t *testing.T) {
+	tests := []struct {
+		request      string
+		wantMethod   string
+		wantMethodOK bool
+		wantID       string
+	}{
+		{request: `{"jsonrpc":"2.0","id":1,"method":"ping"}`, wantMethod: "ping", wantMethodOK: true, wantID: "1"},
+		{
+			request:      `{"jsonrpc":"2.0","id":"a","method":"ping","params":[1]}`,
+			wantMethod:   "ping",
+			wantMethodOK: true,
+			wantID:       `"a"`,
+		},
+		{request: `{"jsonrpc":"2.0","id":null,"method":"ping"}`, wantMethod: "ping", wantMethodOK: true, wantID: "null"},
+		{request: `{"jsonrpc":"2.0","method":"notify"}`, wantMethod: "notify", wantMethodOK: true},
+		{request: `{"jsonrpc":"2.0","method":""}`, wantMethodOK: true},
+		{request: `{"jsonrpc":"2.0","id":1}`, wantID: "1"},
+		{request: `not json`},
+	}
+	parser := JSONRPCParser{}
+	for _, tt := range tests {
+		method, ok := parser.Method([]byte(tt.request))
+		if ok != tt.wantMethodOK || method != tt.wantMethod {
+			t.Errorf("Method(%s) = %q, %v, want %q, %v", tt.request, method, ok, tt.wantMethod, tt.wantMethodOK)
+		}
+		id, ok := parser.ID([]byte(tt.request))
+		if ok != (tt.wantID != "") || string(id) != tt.wantID {
+			t.Errorf("ID(%s) = %s, %v, want %s", tt.request, id, ok, tt.wantID)
+		}
+	}
+}
+
+func TestJSONRPCParserBatch(t *testing.T) {
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"a"},{"jsonrpc":"2.0","method":"b"}]`
+	parser := JSONRPCParser{}
+
+	if methods, ok := requestMethods(parser, []byte(batch)); !ok || !reflect.DeepEqual(methods, []string{"a", "b"}) {
+		t.Errorf("methods = %q, %v, want [a b]", methods, ok)
+	}
+	// A batch has no id of its own
+	if id, ok := parseID(parser, []byte(batch)); ok {
+		t.Errorf("id of batch = %s, want none", id)
+	}
+	if methods, ok := requestMethods(parser, []byte(`[{"jsonrpc":"2.0","id":1}]`)); ok {
+		t.Errorf("methods of batch with a request without method = %q, want none", methods)
+	}
+}
+
+// commandParser is RequestParser of a dialect with cmd and seq members instead of method and id
+type commandParser struct{}
+
+func (commandParser) Method(request []byte) (string, bool) {
+	var message struct {
+		Cmd *string `json:"cmd"`
+	}
+	if err := json.Unmarshal(request, &message); err != nil || message.Cmd == nil {
+		return "", false
+	}
+	return *message.Cmd, true
+}
+
+func (commandParser) ID(request []byte) (json.RawMessage, bool) {
+	var message struct {
+		Seq json.RawMessage `json:"seq"`
+	}
+	if err := json.Unmarshal(request, &message); err != nil || message.Seq == nil {
+		return nil, false
+	}
+	return message.Seq, true
+}
+
+func TestFSProxyRequestParser(t *testing.T) {
+	requests := make(chan string, 10)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		requests <- string(body)
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	})
+	allow := func(method string) bool {
+		return method != "stop"
+	}
+	p := newTestProxy(t, server.URL,
+		WithRequestParser(commandParser{}),
+		WithMethodFilter(allow),
+		WithResponseIDAnnotation(),
+		WithMaxConcurrency(1),
+	).start()
+
+	p.write(`{"cmd":"stop","seq":1}`, `{"cmd":"status","seq":2}`)
+	lines := p.waitLines(1)
+
+	// The line with the filtered command is not sent, and the response is annotated with seq
+	if request := <-requests; request != `{"cmd":"status","seq":2}` {
+		t.Errorf("request = %q, want the status command", request)
+	}
+	if want := `{"id":2,"response":{"ok":true}}`; lines[0] != want {
+		t.Errorf("output = %q, want %q", lines[0], want)
+	}
+}
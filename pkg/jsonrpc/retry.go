@@ -0,0 +1,96 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy describes how failed RPC requests are retried.
+// Connection errors, 5xx and 429 responses are retried, other 4xx responses are not
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one
+	MaxAttempts int `yaml:"maxAttempts"`
+	// BaseDelay is the delay before the first retry
+	BaseDelay time.Duration `yaml:"baseDelay"`
+	// Multiplier is the factor the delay grows by after each retry
+	Multiplier float64 `yaml:"multiplier"`
+	// MaxDelay caps the delay between attempts. Zero means no cap
+	MaxDelay time.Duration `yaml:"maxDelay"`
+	// RetryAfter makes the delay after 429 and 503 responses be taken from
+	// their Retry-After header, capped by MaxDelay
+	RetryAfter bool `yaml:"retryAfter"`
+}
+
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := time.Duration(float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt-1)))
+	return p.capDelay(delay)
+}
+
+// delayAfter returns the delay before the next attempt after err
+func (p RetryPolicy) delayAfter(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if p.RetryAfter && errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return p.capDelay(statusErr.RetryAfter)
+	}
+	return p.delay(attempt)
+}
+
+func (p RetryPolicy) capDelay(delay time.Duration) time.Duration {
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay < 0) {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// isRetryable reports whether the request failed with err may succeed if it is sent again:
+// on connection errors, timeouts of the request, 5xx and 429 responses. Such errors are also
+// counted by the circuit breaker. Errors of preparing the request, e.g. of the token provider,
+// and cancellation of the proxy are not retryable
+func isRetryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	// Errors of http.Client are net.Error, including the cancellation
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// parseRetryAfter returns the delay in Retry-After header value, which is either
+// a number of seconds or an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := date.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
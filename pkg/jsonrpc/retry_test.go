@@ -0,0 +1,218 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingHandler fails the first failures requests with status and passes the others to next
+func failingHandler(failures int32, status int, next http.HandlerFunc) (http.HandlerFunc, *int32) {
+	var attempts int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= failures {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		next(w, r)
+	}, &attempts
+}
+
+func TestFSProxyRetry(t *testing.T) {
+	handler, attempts := failingHandler(2, http.StatusInternalServerError, echoHandler)
+	server := newRPCServer(t, handler)
+	p := newTestProxy(t, server.URL, WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		Multiplier:  2,
+	})).start()
+
+	p.write(rpcRequest(1, "ping"))
+	lines := p.waitLines(1)
+
+	if lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+	if got := atomic.LoadInt32(attempts); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestFSProxyRetryClientErrorNotRetried(t *testing.T) {
+	handler, attempts := failingHandler(1, http.StatusBadRequest, echoHandler)
+	server := newRPCServer(t, handler)
+	p := newTestProxy(t, server.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 3})).start()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLineError()
+
+	if got := atomic.LoadInt32(attempts); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: &StatusError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "too many requests", err: &StatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "client error", err: &StatusError{StatusCode: http.StatusBadRequest}},
+		{
+			name: "connection refused",
+			err:  &url.Error{Op: "Post", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+			want: true,
+		},
+		{name: "request timeout", err: &url.Error{Op: "Post", Err: context.DeadlineExceeded}, want: true},
+		{name: "unexpected EOF", err: fmt.Errorf("read response body: %w", io.ErrUnexpectedEOF), want: true},
+		{name: "cancelled", err: &url.Error{Op: "Post", Err: context.Canceled}},
+		{name: "circuit open", err: ErrCircuitOpen},
+		{name: "token provider", err: fmt.Errorf("get token: %w", errors.New("token expired"))},
+		{name: "dropped line", err: &LineError{Err: ErrLineDropped}},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestFSProxyTokenProviderErrorNotRetried(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	var calls int32
+	provider := func(context.Context) (string, error) {
+		atomic.AddInt32(&calls, 1)
+		return "", errors.New("token expired")
+	}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	p := newTestProxy(t, server.URL, WithTokenProvider(provider), WithRetryPolicy(policy)).start()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLineError()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("token provider calls = %d, want 1", got)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, Multiplier: 2, MaxDelay: 300 * time.Millisecond}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 100 * time.Millisecond},
+		{attempt: 2, want: 200 * time.Millisecond},
+		{attempt: 3, want: 300 * time.Millisecond},
+		{attempt: 10, want: 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := policy.delay(tt.attempt); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestFSProxyIdempotencyKeyOnRetry(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	handler, _ := failingHandler(1, http.StatusBadGateway, echoHandler)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(defaultIdempotencyHeader))
+		mu.Unlock()
+		handler(w, r)
+	})
+	p := newTestProxy(t, server.URL, WithIdempotencyKey(""), WithRetryPolicy(RetryPolicy{MaxAttempts: 2})).start()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("idempotency keys = %q, want the same key for both attempts", keys)
+	}
+	if keys[0] != idempotencyKey([]byte(rpcRequest(1, "ping"))) {
+		t.Errorf("idempotency key = %q, want the hash of the request", keys[0])
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{value: "", wantOK: false},
+		{value: "3", want: 3 * time.Second, wantOK: true},
+		{value: "-1", wantOK: false},
+		{value: "Sun, 01 May 2022 12:00:10 GMT", want: 10 * time.Second, wantOK: true},
+		{value: "Sun, 01 May 2022 11:00:00 GMT", want: 0, wantOK: true},
+		{value: "soon", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestRetryPolicyDelayAfter(t *testing.T) {
+	limited := &StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute}
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   time.Duration
+	}{
+		{name: "ignored", policy: RetryPolicy{BaseDelay: time.Second}, want: time.Second},
+		{name: "honored", policy: RetryPolicy{BaseDelay: time.Second, RetryAfter: true}, want: time.Minute},
+		{
+			name:   "capped",
+			policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second, RetryAfter: true},
+			want:   10 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.delayAfter(1, limited); got != tt.want {
+			t.Errorf("%s: delay = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFSProxyRetryAfter(t *testing.T) {
+	var attempts int32
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+			return
+		}
+		echoHandler(w, r)
+	})
+	p := newTestProxy(t, server.URL, WithRetryPolicy(RetryPolicy{
+		MaxAttempts: 2,
+		BaseDelay:   time.Millisecond,
+		RetryAfter:  true,
+	})).start()
+	start := time.Now()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want at least Retry-After of 1s", elapsed)
+	}
+}
@@ -0,0 +1,81 @@
+package jsonrpc
+
+import (
+	"context"
+	"strings"
+)
+
+// methodRoute sends requests with methods matching pattern to rpcURL.
+// Pattern ending with "*" matches methods with the prefix before it
+type methodRoute struct {
+	pattern string
+	rpcURL  string
+}
+
+func (r methodRoute) match(method string) bool {
+	if prefix := strings.TrimSuffix(r.pattern, "*"); prefix != r.pattern {
+		return strings.HasPrefix(method, prefix)
+	}
+	return method == r.pattern
+}
+
+// routeSender sends requests using the sender of the first route matching their methods.
+// Requests matching no route, and batches whose methods match different routes,
+// are sent using fallback
+type routeSender struct {
+	routes   []methodRoute
+	senders  []Sender // senders[i] sends requests of routes[i]
+	fallback Sender
+	parser   RequestParser
+}
+
+func newRouteSender(routes []methodRoute, senders []Sender, fallback Sender, parser RequestParser) *routeSender {
+	return &routeSender{
+		routes:   routes,
+		senders:  senders,
+		fallback: fallback,
+		parser:   parser,
+	}
+}
+
+func (s *routeSender) Send(ctx context.Context, payload []byte) ([]byte, error) {
+	response, _, err := s.sendWithStatus(ctx, payload)
+	return response, err
+}
+
+func (s *routeSender) sendWithStatus(ctx context.Context, payload []byte) ([]byte, int, error) {
+	return sendWithStatus(ctx, s.route(payload), payload)
+}
+
+func (s *routeSender) route(payload []byte) Sender {
+	if route := matchRoute(s.parser, s.routes, payload); route >= 0 {
+		return s.senders[route]
+	}
+	return s.fallback
+}
+
+// matchRoute returns the index of the route of payload or -1 if the fallback is used
+func matchRoute(parser RequestParser, routes []methodRoute, payload []byte) int {
+	methods, ok := requestMethods(parser, payload)
+	if !ok {
+		return -1
+	}
+	route := -1
+	for i, method := range methods {
+		methodRoute := routeIndex(routes, method)
+		if i > 0 && methodRoute != route {
+			return -1
+		}
+		route = methodRoute
+	}
+	return route
+}
+
+func routeIndex(routes []methodRoute, method string) int {
+	for i, route := range routes {
+		if route.match(method) {
+			return i
+		}
+	}
+	return -1
+}
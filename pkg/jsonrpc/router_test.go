@@ -0,0 +1,94 @@
+package jsonrpc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFSProxyMethodFilter(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	allow := func(method string) bool {
+		return method != "admin_stop"
+	}
+	p := newTestProxy(t, server.URL, WithMethodFilter(allow), WithMaxConcurrency(1)).start()
+
+	p.write(
+		rpcRequest(1, "admin_stop"),
+		`[`+rpcRequest(2, "ping")+`,`+rpcRequest(3, "admin_stop")+`]`,
+		`{"jsonrpc":"2.0","id":4}`,
+		rpcRequest(5, "ping"),
+	)
+	p.waitLines(1)
+	eventually(t, func() bool {
+		return p.Stats().Dropped[dropFiltered] == 3
+	}, "filtered lines")
+
+	// A batch with a method not allowed and a line without method are skipped too
+	if got, want := recorder.received(), []string{rpcRequest(5, "ping")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %q, want %q", got, want)
+	}
+}
+
+func TestFSProxyMethodRoute(t *testing.T) {
+	fallback := &recordingServer{}
+	fallbackServer := newRPCServer(t, fallback.handle)
+	exact := &recordingServer{}
+	exactServer := newRPCServer(t, exact.handle)
+	prefix := &recordingServer{}
+	prefixServer := newRPCServer(t, prefix.handle)
+	p := newTestProxy(t, fallbackServer.URL,
+		WithMethodRoute("eth_call", exactServer.URL),
+		WithMethodRoute("eth_*", prefixServer.URL),
+		WithMaxConcurrency(1),
+	).start()
+
+	mixedBatch := `[` + rpcRequest(4, "eth_call") + `,` + rpcRequest(5, "eth_getBalance") + `]`
+	p.write(
+		rpcRequest(1, "eth_call"),
+		rpcRequest(2, "eth_getBalance"),
+		rpcRequest(3, "net_version"),
+		mixedBatch,
+	)
+	lines := p.waitLines(4)
+
+	tests := []struct {
+		name   string
+		server *recordingServer
+		want   []string
+	}{
+		// Routes are checked in the order they are added
+		{name: "exact", server: exact, want: []string{rpcRequest(1, "eth_call")}},
+		{name: "prefix", server: prefix, want: []string{rpcRequest(2, "eth_getBalance")}},
+		// A batch with methods of different routes is not routed
+		{name: "fallback", server: fallback, want: []string{rpcRequest(3, "net_version"), mixedBatch}},
+	}
+	for _, tt := range tests {
+		if got := tt.server.received(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s route requests = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if lines[0] != rpcResult(1, "eth_call") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "eth_call"))
+	}
+}
+
+func TestMethodRouteMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		method  string
+		want    bool
+	}{
+		{pattern: "eth_call", method: "eth_call", want: true},
+		{pattern: "eth_call", method: "eth_callMany", want: false},
+		{pattern: "eth_*", method: "eth_getBalance", want: true},
+		{pattern: "eth_*", method: "eth_", want: true},
+		{pattern: "eth_*", method: "net_version", want: false},
+		{pattern: "*", method: "anything", want: true},
+	}
+	for _, tt := range tests {
+		if got := (methodRoute{pattern: tt.pattern}).match(tt.method); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.method, got, tt.want)
+		}
+	}
+}
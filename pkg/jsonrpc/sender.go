@@ -0,0 +1,294 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Sender sends JSON-RPC request payload and returns response payload
+type Sender interface {
+	Send(ctx context.Context, payload []byte) ([]byte, error)
+}
+
+// statusSender is a Sender which also reports the status code of the response
+type statusSender interface {
+	sendWithStatus(ctx context.Context, payload []byte) ([]byte, int, error)
+}
+
+// streamSender is a Sender which can pass the response body to write without reading it into memory
+type streamSender interface {
+	sendStream(ctx context.Context, payload []byte, write func(io.Reader) (int64, error)) (int64, int, error)
+}
+
+// StatusError is returned when the RPC server responds with non-2xx status code.
+// Body is set only for status codes passed to WithErrorBodyStatusCodes.
+// RetryAfter is the delay in Retry-After header of 429 and 503 responses, if any
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+}
+
+// ReadError is returned when response body of the RPC server can not be read
+type ReadError struct {
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("read response body: %v", e.Err)
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPSender sends requests to JSON-RPC server over HTTP
+type HTTPSender struct {
+	rpcURL string
+	client *http.Client
+	header http.Header
+	token  TokenProvider
+	gzip   bool
+	// idempotencyHeader is the header set to the hash of payload
+	idempotencyHeader string
+	// bodyStatusCodes are non-2xx status codes response body is read for
+	bodyStatusCodes map[int]bool
+	// basicAuth is the username and password of basic authentication, if any
+	basicAuth *url.Userinfo
+	// method is the HTTP method of requests, POST if empty
+	method string
+	// queryParam is the query parameter payload is sent in instead of the body, if any
+	queryParam string
+	// userAgent is the User-Agent header, the default one of Go if empty
+	userAgent string
+	// correlationHeader is the header set to the correlation id of the request along with
+	// traceparent header of its span, if any
+	correlationHeader string
+}
+
+// TokenProvider returns bearer token for a request, so the token can be refreshed
+type TokenProvider func(ctx context.Context) (string, error)
+
+// NewHTTPSender creates HTTPSender. If client is nil, http.DefaultClient is used
+func NewHTTPSender(rpcURL string, client *http.Client) *HTTPSender {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPSender{
+		rpcURL: rpcURL,
+		client: client,
+	}
+}
+
+// Send posts payload to rpcURL and returns response body
+func (s *HTTPSender) Send(ctx context.Context, payload []byte) ([]byte, error) {
+	response, _, err := s.sendWithStatus(ctx, payload)
+	return response, err
+}
+
+// sendWithStatus is Send which also returns the status code of the response. It is zero
+// if no response is received
+func (s *HTTPSender) sendWithStatus(ctx context.Context, payload []byte) (response []byte, status int, err error) {
+	resp, err := s.do(ctx, payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close response body: %w", closeErr)
+		}
+	}()
+	return s.readResponse(resp)
+}
+
+// sendStream is sendWithStatus which passes the body of 2xx response to write
+// instead of reading it into memory. It returns the number of bytes written
+func (s *HTTPSender) sendStream(
+	ctx context.Context,
+	payload []byte,
+	write func(io.Reader) (int64, error),
+) (written int64, status int, err error) {
+	resp, err := s.do(ctx, payload)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("close response body: %w", closeErr)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_, status, err := s.readResponse(resp)
+		return 0, status, err
+	}
+
+	reader, err := bodyReader(resp)
+	if err != nil {
+		return 0, resp.StatusCode, err
+	}
+	written, err = write(reader)
+	if err != nil {
+		return written, resp.StatusCode, fmt.Errorf("stream response: %w", err)
+	}
+	return written, resp.StatusCode, nil
+}
+
+// do sends request with payload. The caller must close the response body
+func (s *HTTPSender) do(ctx context.Context, payload []byte) (*http.Response, error) {
+	req, err := s.newRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	if s.userAgent != "" {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+	for key, values := range s.header {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if s.correlationHeader != "" {
+		if id := correlationIDFrom(ctx); id != "" {
+			req.Header.Set(s.correlationHeader, id)
+		}
+		propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+	if s.idempotencyHeader != "" {
+		req.Header.Set(s.idempotencyHeader, idempotencyKey(payload))
+	}
+	if s.basicAuth != nil {
+		password, _ := s.basicAuth.Password()
+		req.SetBasicAuth(s.basicAuth.Username(), password)
+	}
+	if s.token != nil {
+		token, err := s.token(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	captureHeader(ctx, resp.Header)
+	return resp, nil
+}
+
+// readResponse reads the body of resp and returns it or StatusError for non-2xx status code
+func (s *HTTPSender) readResponse(resp *http.Response) ([]byte, int, error) {
+	success := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !success && !s.bodyStatusCodes[resp.StatusCode] {
+		return nil, resp.StatusCode, newStatusError(resp, nil)
+	}
+
+	reader, err := bodyReader(resp)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	response, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, resp.StatusCode, &ReadError{Err: err}
+	}
+	if !success {
+		return nil, resp.StatusCode, newStatusError(resp, response)
+	}
+	return response, resp.StatusCode, nil
+}
+
+// bodyReader returns reader of the response body which decompresses it if needed
+func bodyReader(resp *http.Response) (io.Reader, error) {
+	// The transport decompresses the body itself only if it requested gzip encoding
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") && !resp.Uncompressed {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, &ReadError{Err: fmt.Errorf("gzip reader: %w", err)}
+		}
+		return zr, nil
+	}
+	return resp.Body, nil
+}
+
+// newRequest creates request with payload in the body or in the query parameter
+func (s *HTTPSender) newRequest(ctx context.Context, payload []byte) (*http.Request, error) {
+	method := s.method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	if s.queryParam != "" {
+		rpcURL, err := url.Parse(s.rpcURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse url: %w", err)
+		}
+		query := rpcURL.Query()
+		query.Set(s.queryParam, string(payload))
+		rpcURL.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, method, rpcURL.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("new request: %w", err)
+		}
+		return req, nil
+	}
+
+	body := payload
+	if s.gzip {
+		var err error
+		if body, err = gzipPayload(payload); err != nil {
+			return nil, fmt.Errorf("gzip payload: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.rpcURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	return req, nil
+}
+
+func newStatusError(resp *http.Response, body []byte) *StatusError {
+	statusErr := &StatusError{StatusCode: resp.StatusCode, Body: body}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		statusErr.RetryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+	return statusErr
+}
+
+// idempotencyKey returns the key identifying payload, so it is the same for every retry
+func idempotencyKey(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func gzipPayload(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,310 @@
+package jsonrpc
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFSProxyContentType(t *testing.T) {
+	contentTypes := make(chan string, 1)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		contentTypes <- r.Header.Get("Content-Type")
+		echoHandler(w, r)
+	})
+	p := newTestProxy(t, server.URL).start()
+
+	p.write(rpcRequest(1, "ping"))
+	lines := p.waitLines(1)
+
+	if got := <-contentTypes; got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if want := rpcResult(1, "ping"); lines[0] != want {
+		t.Errorf("output = %q, want %q", lines[0], want)
+	}
+}
+
+func TestFSProxyCustomHeaders(t *testing.T) {
+	server, nextHeader := headerServer(t)
+	header := http.Header{}
+	header.Set("X-Api-Key", "secret")
+	header.Add("X-Tag", "a")
+	header.Add("X-Tag", "b")
+	p := newTestProxy(t, server.URL, WithHeaders(header)).start()
+
+	p.write(rpcRequest(1, "ping"))
+	got := nextHeader()
+
+	if key := got.Get("X-Api-Key"); key != "secret" {
+		t.Errorf("X-Api-Key = %q, want secret", key)
+	}
+	if tags := got.Values("X-Tag"); !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("X-Tag = %q, want [a b]", tags)
+	}
+	if contentType := got.Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", contentType)
+	}
+}
+
+func TestFSProxyBearerToken(t *testing.T) {
+	server, nextHeader := headerServer(t)
+	p := newTestProxy(t, server.URL, WithBearerToken("token")).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if auth := nextHeader().Get("Authorization"); auth != "Bearer token" {
+		t.Errorf("Authorization = %q, want Bearer token", auth)
+	}
+}
+
+func TestFSProxyTokenProvider(t *testing.T) {
+	server, nextHeader := headerServer(t)
+	var calls int32
+	provider := func(context.Context) (string, error) {
+		return fmt.Sprintf("token-%d", atomic.AddInt32(&calls, 1)), nil
+	}
+	p := newTestProxy(t, server.URL, WithTokenProvider(provider), WithMaxConcurrency(1)).start()
+
+	p.write(rpcRequest(1, "first"), rpcRequest(2, "second"))
+
+	// Token is requested for every request, so it can be refreshed
+	for _, want := range []string{"Bearer token-1", "Bearer token-2"} {
+		if auth := nextHeader().Get("Authorization"); auth != want {
+			t.Errorf("Authorization = %q, want %q", auth, want)
+		}
+	}
+}
+
+func TestFSProxyTokenProviderError(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	errToken := errors.New("token expired")
+	provider := func(context.Context) (string, error) {
+		return "", errToken
+	}
+	p := newTestProxy(t, server.URL, WithTokenProvider(provider)).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if lineErr := p.waitLineError(); !errors.Is(lineErr, errToken) {
+		t.Errorf("error = %v, want %v", lineErr, errToken)
+	}
+}
+
+func TestFSProxyGzipRequests(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			http.Error(w, "not compressed", http.StatusBadRequest)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = zr
+		echoHandler(w, r)
+	})
+	p := newTestProxy(t, server.URL, WithGzipRequests()).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+}
+
+func TestFSProxyGzipResponses(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		response, err := echoResponse(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write(response)
+		_ = zw.Close()
+	})
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		// The transport decompresses the response itself
+		{name: "transparent"},
+		// The transport does not decompress responses to requests with Accept-Encoding set
+		{name: "requested", header: http.Header{"Accept-Encoding": []string{"gzip"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, server.URL, WithHeaders(tt.header)).start()
+
+			p.write(rpcRequest(1, "ping"))
+
+			if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+				t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+			}
+		})
+	}
+}
+
+func TestFSProxyAcceptedResponse(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	p := newTestProxy(t, server.URL).start()
+
+	p.write(rpcRequest(1, "ping"))
+	eventually(t, func() bool {
+		return p.Stats().Processed == 1
+	}, "line to be proxied")
+
+	if output := p.output(); output != "" {
+		t.Errorf("output = %q, want empty", output)
+	}
+}
+
+func TestFSProxyErrorBodyStatusCodes(t *testing.T) {
+	const errorBody = `{"jsonrpc":"2.0","id":1,"error":{"code":-32600,"message":"Invalid Request"}}`
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(errorBody))
+	})
+	p := newTestProxy(t, server.URL, WithErrorBodyStatusCodes(http.StatusBadRequest)).start()
+
+	p.write(rpcRequest(1, "ping"))
+	lines := p.waitLines(1)
+
+	if lines[0] != errorBody {
+		t.Errorf("output = %q, want %q", lines[0], errorBody)
+	}
+	var statusErr *StatusError
+	if lineErr := p.waitLineError(); !errors.As(lineErr, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("error = %v, want status error 400", lineErr)
+	}
+}
+
+func TestFSProxyErrorBodyNotWrittenByDefault(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"bad"}`))
+	})
+	p := newTestProxy(t, server.URL).start()
+
+	p.write(rpcRequest(1, "ping"))
+	var statusErr *StatusError
+	if lineErr := p.waitLineError(); !errors.As(lineErr, &statusErr) || statusErr.Body != nil {
+		t.Errorf("error = %v, want status error without body", lineErr)
+	}
+
+	if output := p.output(); output != "" {
+		t.Errorf("output = %q, want empty", output)
+	}
+}
+
+func TestFSProxyBasicAuth(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{name: "basic", opts: []Option{WithBasicAuth("user", "p@ss")}, want: "Basic dXNlcjpwQHNz"},
+		{
+			name: "bearer precedence",
+			opts: []Option{WithBasicAuth("user", "p@ss"), WithBearerToken("token")},
+			want: "Bearer token",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, nextHeader := headerServer(t)
+			p := newTestProxy(t, server.URL, tt.opts...).start()
+
+			p.write(rpcRequest(1, "ping"))
+
+			if auth := nextHeader().Get("Authorization"); auth != tt.want {
+				t.Errorf("Authorization = %q, want %q", auth, tt.want)
+			}
+		})
+	}
+}
+
+func TestFSProxyGetWithQueryParam(t *testing.T) {
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Query of the RPC URL is kept
+		if r.URL.Path != "/rpc" || r.URL.Query().Get("key") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		r.Body = ioutil.NopCloser(strings.NewReader(r.URL.Query().Get("request")))
+		echoHandler(w, r)
+	})
+	p := newTestProxy(t, server.URL+"/rpc?key=secret", WithHTTPMethod(http.MethodGet), WithQueryParam("request")).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+}
+
+func TestFSProxyHTTPMethod(t *testing.T) {
+	methods := make(chan string, 1)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		methods <- r.Method
+		echoHandler(w, r)
+	})
+	p := newTestProxy(t, server.URL, WithHTTPMethod(http.MethodPut)).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+	if method := <-methods; method != http.MethodPut {
+		t.Errorf("method = %s, want PUT", method)
+	}
+}
+
+func TestFSProxyUserAgent(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want string
+	}{
+		{name: "default", want: "jsonrpc-fsproxy/" + Version},
+		{name: "custom", opts: []Option{WithUserAgent("indexer/2.1")}, want: "indexer/2.1"},
+		{
+			name: "headers precedence",
+			opts: []Option{WithUserAgent("indexer/2.1"), WithHeaders(http.Header{"User-Agent": {"override"}})},
+			want: "override",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, nextHeader := headerServer(t)
+			p := newTestProxy(t, server.URL, tt.opts...).start()
+
+			p.write(rpcRequest(1, "ping"))
+
+			if userAgent := nextHeader().Get("User-Agent"); userAgent != tt.want {
+				t.Errorf("User-Agent = %q, want %q", userAgent, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InputFormat defines how messages are delimited in the input file
+type InputFormat int
+
+const (
+	// InputLines means each line is a message
+	InputLines InputFormat = iota
+	// InputFramed means each message is preceded by Content-Length header
+	// as in Language Server Protocol
+	InputFramed
+)
+
+// lineSplitter is a bufio.SplitFunc provider which works as bufio.ScanLines
+// but discards lines longer than maxLineBytes instead of failing with bufio.ErrTooLong.
+// If framed is set, it splits Content-Length framed messages instead of lines.
+// If waitNewline is set, a trailing line without newline is not consumed at EOF
+type lineSplitter struct {
+	maxLineBytes int
+	framed       bool
+	waitNewline  bool
+	onDiscard    func()
+	consumed     int64 // number of bytes consumed so far
+	splitState
+}
+
+// splitState is the state of lineSplitter, which is kept between reads of the input file,
+// as a discarded line or frame may be written in several chunks
+type splitState struct {
+	discarding bool
+	skip       int // number of bytes of the discarded frame left to skip
+}
+
+func (s *lineSplitter) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if s.framed {
+		advance, token, err = s.splitFrame(data, atEOF)
+	} else {
+		advance, token, err = s.splitLine(data, atEOF)
+	}
+	s.consumed += int64(advance)
+	return advance, token, err
+}
+
+func (s *lineSplitter) splitLine(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if s.discarding {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return len(data), nil, nil
+		}
+		s.discarding = false
+		return s.continueSplit(i+1, data, atEOF, s.splitLine)
+	}
+
+	if s.waitNewline && atEOF && len(data) < s.maxLineBytes && bytes.IndexByte(data, '\n') < 0 {
+		return 0, nil, nil
+	}
+	advance, token, err = bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= s.maxLineBytes {
+		s.discarding = true
+		if s.onDiscard != nil {
+			s.onDiscard()
+		}
+		return len(data), nil, nil
+	}
+	return advance, token, err
+}
+
+// splitFrame returns the body of a framed message. An incomplete frame at EOF is not consumed
+func (s *lineSplitter) splitFrame(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if s.skip > 0 {
+		n := s.skip
+		if n >= len(data) {
+			s.skip -= len(data)
+			return len(data), nil, nil
+		}
+		s.skip = 0
+		return s.continueSplit(n, data, atEOF, s.splitFrame)
+	}
+
+	// Skip line breaks between frames
+	start := len(data) - len(bytes.TrimLeft(data, "\r\n"))
+	if start > 0 {
+		return s.continueSplit(start, data, atEOF, s.splitFrame)
+	}
+
+	headerEnd := bytes.Index(data, []byte("\r\n\r\n"))
+	if headerEnd < 0 {
+		if len(data) >= s.maxLineBytes {
+			return 0, nil, errors.New("frame header exceeds max size")
+		}
+		return 0, nil, nil
+	}
+	length, err := contentLength(data[:headerEnd])
+	if err != nil {
+		return 0, nil, fmt.Errorf("parse frame header: %w", err)
+	}
+
+	bodyStart := headerEnd + 4
+	if bodyStart+length > s.maxLineBytes {
+		if s.onDiscard != nil {
+			s.onDiscard()
+		}
+		s.skip = bodyStart + length
+		return s.splitFrame(data, atEOF)
+	}
+	if len(data) < bodyStart+length {
+		return 0, nil, nil
+	}
+	return bodyStart + length, data[bodyStart : bodyStart+length], nil
+}
+
+// continueSplit splits data after the skipped bytes with split. Scanner stops at EOF
+// once no token is returned, so a message following the skipped bytes would not be read
+func (s *lineSplitter) continueSplit(
+	skipped int,
+	data []byte,
+	atEOF bool,
+	split bufio.SplitFunc,
+) (advance int, token []byte, err error) {
+	advance, token, err = split(data[skipped:], atEOF)
+	return skipped + advance, token, err
+}
+
+// contentLength returns the value of Content-Length header of a frame
+func contentLength(header []byte) (int, error) {
+	for _, line := range strings.Split(string(header), "\r\n") {
+		i := strings.IndexByte(line, ':')
+		if i < 0 || !strings.EqualFold(strings.TrimSpace(line[:i]), "Content-Length") {
+			continue
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(line[i+1:]))
+		if err != nil || length < 0 {
+			return 0, fmt.Errorf("invalid Content-Length %q", line[i+1:])
+		}
+		return length, nil
+	}
+	return 0, errors.New("no Content-Length header")
+}
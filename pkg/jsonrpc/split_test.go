@@ -0,0 +1,150 @@
+package jsonrpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var errRead = errors.New("read failed")
+
+// failingReader fails every read
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errRead
+}
+
+func TestStreamProxyReportsScanError(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	proxy, err := NewStreamProxy(server.URL, failingReader{}, ioutil.Discard, nil)
+	if err != nil {
+		t.Fatalf("new proxy: %v", err)
+	}
+	defer proxy.Close()
+
+	if err := proxy.Run(context.Background()); !errors.Is(err, errRead) {
+		t.Errorf("run error = %v, want %v", err, errRead)
+	}
+}
+
+func TestFSProxySkipsOversizeLineWrittenInChunks(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL, WithMaxLineBytes(16)).start()
+
+	p.writeRaw(`{"method":"` + strings.Repeat("a", 32))
+	eventually(t, func() bool {
+		return p.Stats().Dropped[dropOversize] == 1
+	}, "oversize line to be dropped")
+	p.writeRaw(`aaaa"}` + "\n" + `{"id":1}` + "\n")
+	p.waitLines(1)
+
+	if requests := recorder.received(); len(requests) != 1 || requests[0] != `{"id":1}` {
+		t.Errorf("requests = %q, want only the line following the oversize one", requests)
+	}
+}
+
+// frame returns message framed with Content-Length header
+func frame(message string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(message), message)
+}
+
+func TestFSProxyFramedInput(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	p := newTestProxy(t, server.URL, WithInputFormat(InputFramed), WithOrderedOutput()).start()
+
+	p.writeRaw(frame(rpcRequest(1, "first")) + frame(rpcRequest(2, "second")))
+
+	want := []string{rpcResult(1, "first"), rpcResult(2, "second")}
+	if lines := p.waitLines(2); !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
+
+func TestFSProxySkipsOversizeFrameWrittenInChunks(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL, WithInputFormat(InputFramed), WithMaxLineBytes(64)).start()
+	oversize := frame(`{"method":"` + strings.Repeat("a", 100) + `"}`)
+
+	p.writeRaw(oversize[:60])
+	eventually(t, func() bool {
+		return p.Stats().Dropped[dropOversize] == 1
+	}, "oversize frame to be dropped")
+	p.writeRaw(oversize[60:] + frame(`{"id":1}`))
+	p.waitLines(1)
+
+	if requests := recorder.received(); len(requests) != 1 || requests[0] != `{"id":1}` {
+		t.Errorf("requests = %q, want only the frame following the oversize one", requests)
+	}
+}
+
+func TestLineSplitterFrames(t *testing.T) {
+	incomplete := frame(`{"id":3}`)[:len(frame(`{"id":3}`))-1]
+	input := frame(`{"id":1}`) + "\r\n" + frame(`{"id":2}`) + incomplete
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	splitter := &lineSplitter{maxLineBytes: 1024, framed: true}
+	scanner.Split(splitter.split)
+
+	var messages []string
+	for scanner.Scan() {
+		messages = append(messages, scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	// The incomplete frame at EOF is not consumed
+	if want := []string{`{"id":1}`, `{"id":2}`}; !reflect.DeepEqual(messages, want) {
+		t.Errorf("messages = %q, want %q", messages, want)
+	}
+	if want := int64(len(input) - len(incomplete)); splitter.consumed != want {
+		t.Errorf("consumed = %d, want %d", splitter.consumed, want)
+	}
+}
+
+func TestFSProxyPartialLine(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	p := newTestProxy(t, server.URL).start()
+	line := rpcRequest(1, "partial")
+
+	p.writeRaw(line[:10])
+	time.Sleep(100 * time.Millisecond)
+	if requests := recorder.received(); len(requests) != 0 {
+		t.Fatalf("requests before newline = %q, want none", requests)
+	}
+	p.writeRaw(line[10:])
+	time.Sleep(100 * time.Millisecond)
+	p.writeRaw("\n")
+	p.waitLines(1)
+
+	if requests := recorder.received(); len(requests) != 1 || requests[0] != line {
+		t.Errorf("requests = %q, want the complete line", requests)
+	}
+}
+
+func TestLineSplitterWaitsForNewline(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("{\"id\":1}\n{\"id\":"))
+	splitter := &lineSplitter{maxLineBytes: 1024, waitNewline: true}
+	scanner.Split(splitter.split)
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	if want := []string{`{"id":1}`}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	if splitter.consumed != int64(len("{\"id\":1}\n")) {
+		t.Errorf("consumed = %d, want the complete line only", splitter.consumed)
+	}
+}
@@ -0,0 +1,76 @@
+package jsonrpc
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats are counters of requests processed by FSProxy
+type Stats struct {
+	// Processed is the number of lines proxied successfully
+	Processed int64
+	// Failed is the number of lines which could not be proxied
+	Failed int64
+	// InFlight is the number of lines being proxied at the moment
+	InFlight int64
+	// LastError is the error of the last failed line
+	LastError error
+	// LastErrorAt is the time of the last failed line
+	LastErrorAt time.Time
+	// LastResponseAt is the time of the last successfully proxied line
+	LastResponseAt time.Time
+	// Dropped is the number of skipped lines by reason:
+	// "oversize", "invalid", "filtered", "duplicate" or "unwritable"
+	Dropped map[string]int64
+}
+
+type stats struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func (s *stats) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.InFlight++
+}
+
+func (s *stats) end(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats.InFlight--
+	if err != nil {
+		s.stats.Failed++
+		s.stats.LastError = err
+		s.stats.LastErrorAt = time.Now()
+		return
+	}
+	s.stats.Processed++
+	s.stats.LastResponseAt = time.Now()
+}
+
+func (s *stats) drop(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stats.Dropped == nil {
+		s.stats.Dropped = make(map[string]int64)
+	}
+	s.stats.Dropped[reason]++
+}
+
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.stats
+	if s.stats.Dropped != nil {
+		snapshot.Dropped = make(map[string]int64, len(s.stats.Dropped))
+		for reason, n := range s.stats.Dropped {
+			snapshot.Dropped[reason] = n
+		}
+	}
+	return snapshot
+}
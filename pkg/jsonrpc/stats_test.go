@@ -0,0 +1,44 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFSProxyStats(t *testing.T) {
+	server := newRPCServer(t, failMethodHandler("fail", http.StatusInternalServerError))
+	p := newTestProxy(t, server.URL, WithInputValidation()).start()
+
+	p.write("invalid", rpcRequest(1, "succeed"), rpcRequest(2, "fail"))
+	var stats Stats
+	eventually(t, func() bool {
+		stats = p.Stats()
+		return stats.Processed+stats.Failed == 2
+	}, "2 proxied lines")
+
+	if stats.Processed != 1 || stats.Failed != 1 || stats.InFlight != 0 {
+		t.Errorf("processed, failed, in flight = %d, %d, %d, want 1, 1, 0",
+			stats.Processed, stats.Failed, stats.InFlight)
+	}
+	if stats.LastError == nil || stats.LastErrorAt.IsZero() {
+		t.Errorf("last error = %v at %v, want the failure", stats.LastError, stats.LastErrorAt)
+	}
+	if stats.LastResponseAt.IsZero() {
+		t.Error("last response time is not set")
+	}
+	if stats.Dropped[dropInvalid] != 1 {
+		t.Errorf("dropped = %v, want 1 invalid", stats.Dropped)
+	}
+}
+
+func TestStatsSnapshotIsCopy(t *testing.T) {
+	var s stats
+	s.drop(dropInvalid)
+
+	snapshot := s.snapshot()
+	snapshot.Dropped[dropInvalid] = 10
+
+	if dropped := s.snapshot().Dropped[dropInvalid]; dropped != 1 {
+		t.Errorf("dropped after changing snapshot = %d, want 1", dropped)
+	}
+}
@@ -0,0 +1,46 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/evsamsonov/jsonrpc-fsproxy/pkg/jsonrpc"
+
+// startSpan starts span of processing a single input line
+func (w *FSProxy) startSpan(ctx context.Context, rpcURL, line string) (context.Context, trace.Span) {
+	return w.tracer.Start(
+		ctx,
+		"jsonrpc-fsproxy.request",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.url", rpcURL),
+			attribute.Int("rpc.request.size", len(line)),
+		),
+	)
+}
+
+// endSpan records the result of processing a line and ends span.
+// Status is the HTTP status of the response, 0 if it is not known, e.g. in dry run
+func endSpan(span trace.Span, status, responseSize int, err error) {
+	defer span.End()
+
+	var statusErr *StatusError
+	if status == 0 && errors.As(err, &statusErr) {
+		status = statusErr.StatusCode
+	}
+	if status > 0 {
+		span.SetAttributes(attribute.Int("http.status_code", status))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int("rpc.response.size", responseSize))
+	span.SetStatus(codes.Ok, "")
+}
@@ -0,0 +1,53 @@
+package jsonrpc
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestFSProxyTracing(t *testing.T) {
+	server := newRPCServer(t, failMethodHandler("fail", http.StatusInternalServerError))
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	p := newTestProxy(t, server.URL, WithTracerProvider(provider), WithMaxConcurrency(1)).start()
+
+	p.write(rpcRequest(1, "succeed"), rpcRequest(2, "fail"))
+	eventually(t, func() bool {
+		return len(recorder.Ended()) == 2
+	}, "2 spans")
+
+	tests := []struct {
+		status     int
+		statusCode codes.Code
+	}{
+		{status: http.StatusOK, statusCode: codes.Ok},
+		{status: http.StatusInternalServerError, statusCode: codes.Error},
+	}
+	spans := recorder.Ended()
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name() != "jsonrpc-fsproxy.request" {
+			t.Errorf("span %d name = %q", i, span.Name())
+		}
+		if span.Status().Code != tt.statusCode {
+			t.Errorf("span %d status = %v, want %v", i, span.Status().Code, tt.statusCode)
+		}
+		if got := spanAttribute(span, "http.status_code"); got != attribute.IntValue(tt.status) {
+			t.Errorf("span %d http.status_code = %v, want %d", i, got.Emit(), tt.status)
+		}
+	}
+}
+
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
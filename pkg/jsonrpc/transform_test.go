@@ -0,0 +1,102 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFSProxyRequestTransform(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	rename := func(request []byte) ([]byte, error) {
+		return bytes.Replace(request, []byte(`"method":"ping"`), []byte(`"method":"renamed"`), 1), nil
+	}
+	addParams := func(request []byte) ([]byte, error) {
+		return bytes.Replace(request, []byte(`"method":"renamed"`), []byte(`"method":"renamed","params":[]`), 1), nil
+	}
+	p := newTestProxy(t, server.URL, WithRequestTransform(rename), WithRequestTransform(addParams)).start()
+
+	p.write(rpcRequest(1, "ping"))
+	lines := p.waitLines(1)
+
+	// Transforms are applied in the order they are added
+	if want := `{"id":1,"jsonrpc":"2.0","method":"renamed","params":[]}`; recorder.received()[0] != want {
+		t.Errorf("request = %q, want %q", recorder.received()[0], want)
+	}
+	if lines[0] != rpcResult(1, "renamed") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "renamed"))
+	}
+}
+
+func TestFSProxyResponseTransform(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	var requests []string
+	redact := func(request, response []byte) ([]byte, error) {
+		requests = append(requests, string(request))
+		return bytes.Replace(response, []byte(`"result":"secret"`), []byte(`"result":"***"`), 1), nil
+	}
+	p := newTestProxy(t, server.URL, WithResponseTransform(redact)).start()
+
+	p.write(rpcRequest(1, "secret"))
+	lines := p.waitLines(1)
+
+	if want := `{"jsonrpc":"2.0","id":1,"result":"***"}`; lines[0] != want {
+		t.Errorf("output = %q, want %q", lines[0], want)
+	}
+	if err := p.stop(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if len(requests) != 1 || requests[0] != rpcRequest(1, "secret") {
+		t.Errorf("transformed requests = %q, want the original request", requests)
+	}
+}
+
+func TestFSProxyTransformError(t *testing.T) {
+	recorder := &recordingServer{}
+	server := newRPCServer(t, recorder.handle)
+	errTransform := errors.New("transform failed")
+	tests := []struct {
+		name string
+		opt  Option
+		sent int
+	}{
+		{
+			name: "request",
+			opt: WithRequestTransform(func([]byte) ([]byte, error) {
+				return nil, errTransform
+			}),
+		},
+		{
+			name: "response",
+			opt: WithResponseTransform(func([]byte, []byte) ([]byte, error) {
+				return nil, errTransform
+			}),
+			sent: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sentBefore := len(recorder.received())
+			deadLetterPath := filepath.Join(t.TempDir(), "dead-letter")
+			p := newTestProxy(t, server.URL, tt.opt, WithDeadLetterFile(deadLetterPath)).start()
+
+			p.write(rpcRequest(1, "ping"))
+			eventually(t, func() bool {
+				return len(splitLines(readFile(t, deadLetterPath))) == 1
+			}, "dead letter")
+
+			if deadLetter := readFile(t, deadLetterPath); !strings.Contains(deadLetter, errTransform.Error()) {
+				t.Errorf("dead letter = %q, want reason %q", deadLetter, errTransform)
+			}
+			if sent := len(recorder.received()) - sentBefore; sent != tt.sent {
+				t.Errorf("sent requests = %d, want %d", sent, tt.sent)
+			}
+			if output := p.output(); output != "" {
+				t.Errorf("output = %q, want empty", output)
+			}
+		})
+	}
+}
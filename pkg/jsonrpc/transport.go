@@ -0,0 +1,95 @@
+package jsonrpc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// newHTTPClient creates the client used when no client is set with WithHTTPClient.
+// It is shared by all requests, so their connections are reused
+func newHTTPClient(o *options) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(o)
+	if err != nil {
+		return nil, fmt.Errorf("new TLS config: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if o.proxyURL != "" {
+		proxyURL, err := url.Parse(o.proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy URL: %w", err)
+		}
+		if proxyURL.Host == "" {
+			return nil, fmt.Errorf("proxy URL %q has no host", o.proxyURL)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	// By default only 2 idle connections per host are kept, so concurrent requests
+	// to the RPC server would open new connections instead of reusing them
+	idleConns := o.maxIdleConns
+	if idleConns <= 0 {
+		idleConns = o.maxConcurrency
+	}
+	if idleConns <= 0 {
+		idleConns = defaultMaxConcurrency
+	}
+	transport.MaxIdleConnsPerHost = idleConns
+	if transport.MaxIdleConns < idleConns {
+		transport.MaxIdleConns = idleConns
+	}
+	if o.idleConnTimeout > 0 {
+		transport.IdleConnTimeout = o.idleConnTimeout
+	}
+	// Requests are limited by the context timeout set with WithRequestTimeout,
+	// so Timeout of the client is not set to let it be disabled or raised
+	return &http.Client{Transport: transport}, nil
+}
+
+func newTLSConfig(o *options) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if o.tlsConfig != nil {
+		tlsConfig = o.tlsConfig.Clone()
+	}
+	if o.clientCertFile != "" || o.clientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.clientCertFile, o.clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	if o.caFile != "" || len(o.caPEM) > 0 {
+		pool, err := newCertPool(o.caFile, o.caPEM)
+		if err != nil {
+			return nil, fmt.Errorf("load CA certificates: %w", err)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if o.tlsServerName != "" {
+		tlsConfig.ServerName = o.tlsServerName
+	}
+	if o.insecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true // nolint:gosec
+	}
+	return tlsConfig, nil
+}
+
+// newCertPool creates pool of PEM encoded certificates of file, if it is set, and pem
+func newCertPool(file string, pem []byte) (*x509.CertPool, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pem = append(append(data, '\n'), pem...)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return pool, nil
+}
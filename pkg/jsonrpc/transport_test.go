@@ -0,0 +1,505 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingTransport counts requests passed to the default transport
+type countingTransport struct {
+	requests int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestFSProxyHTTPClient(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	transport := &countingTransport{}
+	p := newTestProxy(t, server.URL, WithHTTPClient(&http.Client{Transport: transport})).start()
+
+	p.write(rpcRequest(1, "ping"))
+	p.waitLines(1)
+
+	if requests := atomic.LoadInt32(&transport.requests); requests != 1 {
+		t.Errorf("requests of the client = %d, want 1", requests)
+	}
+}
+
+func TestNewHTTPClientHasNoTimeout(t *testing.T) {
+	o := defaultOptions()
+	client, err := newHTTPClient(&o)
+	if err != nil {
+		t.Fatalf("new HTTP client: %v", err)
+	}
+	// Requests are limited by WithRequestTimeout only
+	if client.Timeout != 0 {
+		t.Errorf("client timeout = %v, want none", client.Timeout)
+	}
+}
+
+func TestFSProxyClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTLSServer(t, ca, ca.pool())
+	clientCertPEM, clientKeyPEM := ca.issuePEM(t, "client", x509.ExtKeyUsageClientAuth)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	appendFile(t, certFile, string(clientCertPEM))
+	appendFile(t, keyFile, string(clientKeyPEM))
+	tlsConfig := &tls.Config{RootCAs: ca.pool()}
+
+	t.Run("with certificate", func(t *testing.T) {
+		p := newTestProxy(t, server.URL, WithTLSConfig(tlsConfig), WithClientCertificate(certFile, keyFile)).start()
+		p.write(rpcRequest(1, "ping"))
+
+		if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+			t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+		}
+	})
+	t.Run("without certificate", func(t *testing.T) {
+		p := newTestProxy(t, server.URL, WithTLSConfig(tlsConfig)).start()
+		p.write(rpcRequest(1, "ping"))
+
+		if lineErr := p.waitLineError(); lineErr == nil {
+			t.Error("request without client certificate succeeded")
+		}
+	})
+}
+
+func TestNewFSProxyInvalidClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewFSProxy("https://localhost", filepath.Join(dir, "input"), filepath.Join(dir, "output"), nil,
+		WithClientCertificate(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key")))
+	if err == nil {
+		t.Error("proxy is created with a missing client certificate")
+	}
+}
+
+func TestFSProxyInsecureSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(echoHandler))
+	t.Cleanup(server.Close)
+
+	t.Run("verified", func(t *testing.T) {
+		p := newTestProxy(t, server.URL).start()
+		p.write(rpcRequest(1, "ping"))
+
+		if lineErr := p.waitLineError(); !strings.Contains(lineErr.Error(), "certificate") {
+			t.Errorf("error = %v, want certificate verification error", lineErr)
+		}
+	})
+	t.Run("skipped", func(t *testing.T) {
+		p := newTestProxy(t, server.URL, WithInsecureSkipVerify()).start()
+		p.write(rpcRequest(1, "ping"))
+
+		if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+			t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+		}
+	})
+}
+
+// testCA is a certificate authority issuing certificates for tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issuePEM issues a certificate for name, which is also valid for 127.0.0.1,
+// and returns it with its key PEM encoded
+func (ca *testCA) issuePEM(t *testing.T, name string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// issue issues a certificate for name as issuePEM does
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(ca.issuePEM(t, name, usage))
+	if err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	return cert
+}
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return key
+}
+
+// newTLSServer starts a test server with the certificate for "rpc.test" issued by ca, replying
+// with echoHandler. If clientCAs is set, clients must have a certificate issued by them
+func newTLSServer(t *testing.T, ca *testCA, clientCAs *x509.CertPool) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{ca.issue(t, "rpc.test", x509.ExtKeyUsageServerAuth)},
+	}
+	if clientCAs != nil {
+		server.TLS.ClientCAs = clientCAs
+		server.TLS.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newConnCountingServer starts a test server replying with echoHandler which counts accepted connections
+func newConnCountingServer(tb testing.TB) (*httptest.Server, *int32) {
+	tb.Helper()
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(echoHandler))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	tb.Cleanup(server.Close)
+	return server, &conns
+}
+
+func TestFSProxyReusesConnections(t *testing.T) {
+	const maxConcurrency, lines = 4, 100
+	server, conns := newConnCountingServer(t)
+	p := newTestProxy(t, server.URL, WithMaxConcurrency(maxConcurrency)).start()
+
+	for i := 0; i < lines; i++ {
+		p.write(rpcRequest(i, "ping"))
+	}
+	p.waitLines(lines)
+
+	if got := atomic.LoadInt32(conns); got > maxConcurrency {
+		t.Errorf("connections = %d, want at most %d", got, maxConcurrency)
+	}
+}
+
+func TestNewHTTPClientIdleConnections(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []Option
+		wantIdle    int
+		wantTimeout time.Duration
+	}{
+		{name: "default", wantIdle: defaultMaxConcurrency, wantTimeout: 90 * time.Second},
+		{name: "max concurrency", opts: []Option{WithMaxConcurrency(8)}, wantIdle: 8, wantTimeout: 90 * time.Second},
+		{
+			name:        "idle connections",
+			opts:        []Option{WithMaxConcurrency(8), WithIdleConnections(200, time.Minute)},
+			wantIdle:    200,
+			wantTimeout: time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		o := defaultOptions()
+		for _, opt := range tt.opts {
+			opt(&o)
+		}
+		client, err := newHTTPClient(&o)
+		if err != nil {
+			t.Fatalf("new HTTP client: %v", err)
+		}
+		transport := client.Transport.(*http.Transport)
+		if transport.MaxIdleConnsPerHost != tt.wantIdle || transport.MaxIdleConns < tt.wantIdle {
+			t.Errorf("%s: idle connections = %d per host of %d, want %d",
+				tt.name, transport.MaxIdleConnsPerHost, transport.MaxIdleConns, tt.wantIdle)
+		}
+		if transport.IdleConnTimeout != tt.wantTimeout {
+			t.Errorf("%s: idle timeout = %v, want %v", tt.name, transport.IdleConnTimeout, tt.wantTimeout)
+		}
+	}
+}
+
+func BenchmarkHTTPSenderConnections(b *testing.B) {
+	server, conns := newConnCountingServer(b)
+	payload := []byte(rpcRequest(1, "ping"))
+	benchmarks := []struct {
+		name   string
+		client func() *http.Client
+	}{
+		{
+			name: "reused",
+			client: func() *http.Client {
+				o := defaultOptions()
+				WithIdleConnections(64, time.Minute)(&o)
+				client, err := newHTTPClient(&o)
+				if err != nil {
+					b.Fatalf("new HTTP client: %v", err)
+				}
+				return client
+			},
+		},
+		{
+			name: "new per request",
+			client: func() *http.Client {
+				transport := http.DefaultTransport.(*http.Transport).Clone()
+				transport.DisableKeepAlives = true
+				return &http.Client{Transport: transport}
+			},
+		},
+	}
+	for _, bb := range benchmarks {
+		b.Run(bb.name, func(b *testing.B) {
+			client := bb.client()
+			defer client.CloseIdleConnections()
+			sender := NewHTTPSender(server.URL, client)
+			connsBefore := atomic.LoadInt32(conns)
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := sender.Send(context.Background(), payload); err != nil {
+						b.Errorf("send: %v", err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+			b.ReportMetric(float64(atomic.LoadInt32(conns)-connsBefore), "conns")
+		})
+	}
+}
+
+func TestFSProxyTLSServerName(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTLSServer(t, ca, nil)
+	// The certificate is not valid for localhost, so the server name has to be overridden
+	rpcURL := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	tlsConfig := &tls.Config{RootCAs: ca.pool()}
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr bool
+	}{
+		{name: "override", opts: []Option{WithTLSServerName("rpc.test")}},
+		{name: "no override", wantErr: true},
+		{name: "wrong override", opts: []Option{WithTLSServerName("other.test")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, rpcURL, append([]Option{WithTLSConfig(tlsConfig)}, tt.opts...)...).start()
+			p.write(rpcRequest(1, "ping"))
+
+			if tt.wantErr {
+				if lineErr := p.waitLineError(); !strings.Contains(lineErr.Error(), "certificate") {
+					t.Errorf("error = %v, want certificate verification error", lineErr)
+				}
+				return
+			}
+			if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+				t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+			}
+		})
+	}
+}
+
+func TestFSProxyCACertificates(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTLSServer(t, ca, nil)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	appendFile(t, caFile, string(ca.pem))
+	otherCA := newTestCA(t)
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{name: "file", opts: []Option{WithCAFile(caFile)}},
+		{name: "PEM", opts: []Option{WithCAPEM(ca.pem)}},
+		// Certificates of both are trusted
+		{name: "file and PEM", opts: []Option{WithCAFile(caFile), WithCAPEM(otherCA.pem)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, server.URL, tt.opts...).start()
+			p.write(rpcRequest(1, "ping"))
+
+			if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+				t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+			}
+		})
+	}
+	t.Run("other CA", func(t *testing.T) {
+		p := newTestProxy(t, server.URL, WithCAPEM(otherCA.pem)).start()
+		p.write(rpcRequest(1, "ping"))
+
+		if lineErr := p.waitLineError(); !strings.Contains(lineErr.Error(), "certificate") {
+			t.Errorf("error = %v, want certificate verification error", lineErr)
+		}
+	})
+}
+
+func TestNewFSProxyInvalidCACertificates(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "missing file", opt: WithCAFile(filepath.Join(dir, "missing.pem"))},
+		{name: "no certificate", opt: WithCAPEM([]byte("not a certificate"))},
+	}
+	for _, tt := range tests {
+		_, err := NewFSProxy("https://localhost", filepath.Join(dir, "input"), filepath.Join(dir, "output"), nil, tt.opt)
+		if err == nil {
+			t.Errorf("%s: proxy is created with invalid CA certificates", tt.name)
+		}
+	}
+}
+
+// newProxyServer starts an HTTP proxy which replies to plain requests with echoHandler itself
+// and tunnels CONNECT requests to their target. The returned function returns the method
+// and the target host of the next request
+func newProxyServer(t *testing.T) (*httptest.Server, func() string) {
+	t.Helper()
+	requests := make(chan string, 10)
+	server := newRPCServer(t, func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.Method + " " + r.URL.Host
+		if r.Method != http.MethodConnect {
+			echoHandler(w, r)
+			return
+		}
+		target, err := net.Dial("tcp", r.URL.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			_ = target.Close()
+			return
+		}
+		go func() {
+			_, _ = io.Copy(target, conn)
+			_ = target.Close()
+		}()
+		_, _ = io.Copy(conn, target)
+		_ = conn.Close()
+	})
+	return server, func() string {
+		t.Helper()
+		select {
+		case request := <-requests:
+			return request
+		case <-time.After(waitTimeout):
+			t.Fatal("timed out waiting for proxy request")
+			return ""
+		}
+	}
+}
+
+func TestFSProxyHTTPProxy(t *testing.T) {
+	ca := newTestCA(t)
+	tlsServer := newTLSServer(t, ca, nil)
+	tlsURL, err := url.Parse(tlsServer.URL)
+	if err != nil {
+		t.Fatalf("parse URL: %v", err)
+	}
+	tests := []struct {
+		name   string
+		rpcURL string
+		opts   []Option
+		want   string
+	}{
+		// The host does not resolve, so the request succeeds only through the proxy
+		{name: "http", rpcURL: "http://rpc.invalid/rpc", want: "POST rpc.invalid"},
+		{name: "https", rpcURL: tlsServer.URL, opts: []Option{WithCAPEM(ca.pem)}, want: "CONNECT " + tlsURL.Host},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, nextRequest := newProxyServer(t)
+			opts := append([]Option{WithHTTPProxy(proxy.URL)}, tt.opts...)
+			p := newTestProxy(t, tt.rpcURL, opts...).start()
+
+			p.write(rpcRequest(1, "ping"))
+
+			if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+				t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+			}
+			if request := nextRequest(); request != tt.want {
+				t.Errorf("proxy request = %q, want %q", request, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFSProxyInvalidHTTPProxy(t *testing.T) {
+	dir := t.TempDir()
+	for _, proxyURL := range []string{"http://proxy:port", "proxy:3128"} {
+		_, err := NewFSProxy("http://localhost", filepath.Join(dir, "input"), filepath.Join(dir, "output"), nil,
+			WithHTTPProxy(proxyURL))
+		if err == nil {
+			t.Errorf("proxy is created with HTTP proxy %q", proxyURL)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	defaultPollInterval = time.Second
+	// maxStatFailures is the number of consecutive failed checks of the input file
+	// after which pollingNotifier fails
+	maxStatFailures = 5
+)
+
+// Watcher is a source of input lines which replaces watching the input file.
+// The proxy stops reading when the channel is closed
+type Watcher interface {
+	Lines(ctx context.Context) <-chan string
+}
+
+// fileEvent is a change of the input file reported by notifier
+type fileEvent int
+
+const (
+	// fileWritten means data is written to the input file
+	fileWritten fileEvent = iota + 1
+	// fileCreated means the input file is created anew, e.g. after rotation
+	fileCreated
+	// lockReleased means the lock file of the input file is removed
+	lockReleased
+)
+
+// notifier reports changes of the input file
+type notifier interface {
+	Events() <-chan fileEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// fsnotifyNotifier reports changes of the input file using fsnotify
+type fsnotifyNotifier struct {
+	watcher     *fsnotify.Watcher
+	path        string
+	eventStream chan fileEvent
+	done        chan struct{}
+}
+
+func newFSNotifyNotifier(path string) (*fsnotifyNotifier, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("new watcher: %w", err)
+	}
+	// Watch the directory to notice when the input file is recreated
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		return nil, fmt.Errorf("watcher add: %w", err)
+	}
+
+	n := &fsnotifyNotifier{
+		watcher:     watcher,
+		path:        path,
+		eventStream: make(chan fileEvent),
+		done:        make(chan struct{}),
+	}
+	go n.run()
+	return n, nil
+}
+
+func (n *fsnotifyNotifier) run() {
+	defer close(n.eventStream)
+	lockFilePath := n.path + ".lock"
+	for event := range n.watcher.Events {
+		var fileEvent fileEvent
+		switch name := filepath.Clean(event.Name); {
+		case name == n.path && event.Op&fsnotify.Create == fsnotify.Create:
+			fileEvent = fileCreated
+		case name == n.path && event.Op&fsnotify.Write == fsnotify.Write:
+			fileEvent = fileWritten
+		case name == lockFilePath && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+			fileEvent = lockReleased
+		default:
+			continue
+		}
+		select {
+		case <-n.done:
+			return
+		case n.eventStream <- fileEvent:
+		}
+	}
+}
+
+func (n *fsnotifyNotifier) Events() <-chan fileEvent {
+	return n.eventStream
+}
+
+func (n *fsnotifyNotifier) Errors() <-chan error {
+	return n.watcher.Errors
+}
+
+func (n *fsnotifyNotifier) Close() error {
+	close(n.done)
+	return n.watcher.Close()
+}
+
+// pollingNotifier reports changes of the input file by checking it every interval.
+// It works on filesystems where fsnotify is not available, e.g. NFS or SMB
+type pollingNotifier struct {
+	path        string
+	interval    time.Duration
+	eventStream chan fileEvent
+	errorStream chan error
+	done        chan struct{}
+}
+
+func newPollingNotifier(path string, interval time.Duration) (*pollingNotifier, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat input file: %w", err)
+	}
+
+	n := &pollingNotifier{
+		path:        path,
+		interval:    interval,
+		eventStream: make(chan fileEvent),
+		errorStream: make(chan error),
+		done:        make(chan struct{}),
+	}
+	go n.run(stat)
+	return n, nil
+}
+
+func (n *pollingNotifier) run(prev os.FileInfo) {
+	defer close(n.eventStream)
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	failures := 0
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		stat, err := os.Stat(n.path)
+		if errors.Is(err, os.ErrNotExist) {
+			// The file may be recreated soon, e.g. on rotation
+			prev = nil
+			continue
+		}
+		if err != nil {
+			// E.g. the file is being replaced or the network filesystem is unavailable for a moment
+			if failures++; failures < maxStatFailures {
+				continue
+			}
+			select {
+			case <-n.done:
+			case n.errorStream <- fmt.Errorf("stat input file: %w", err):
+			}
+			return
+		}
+		failures = 0
+
+		var event fileEvent
+		switch {
+		case prev == nil || !os.SameFile(prev, stat):
+			event = fileCreated
+		case stat.Size() != prev.Size() || !stat.ModTime().Equal(prev.ModTime()):
+			event = fileWritten
+		}
+		prev = stat
+		if event == 0 {
+			continue
+		}
+		select {
+		case <-n.done:
+			return
+		case n.eventStream <- event:
+		}
+	}
+}
+
+func (n *pollingNotifier) Events() <-chan fileEvent {
+	return n.eventStream
+}
+
+func (n *pollingNotifier) Errors() <-chan error {
+	return n.errorStream
+}
+
+func (n *pollingNotifier) Close() error {
+	close(n.done)
+	return nil
+}
@@ -0,0 +1,232 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFSProxyWatcherStrategies(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "fsnotify", opt: WithFSNotify()},
+		{name: "polling", opt: WithPolling(20 * time.Millisecond)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, server.URL, tt.opt).start()
+
+			p.write(rpcRequest(1, "first"))
+			p.waitLines(1)
+			p.write(rpcRequest(2, "second"))
+
+			if lines := p.waitLines(2); lines[1] != rpcResult(2, "second") {
+				t.Errorf("output = %q, want %q", lines[1], rpcResult(2, "second"))
+			}
+		})
+	}
+}
+
+// sliceWatcher is Watcher which passes its lines and closes the channel
+type sliceWatcher []string
+
+func (w sliceWatcher) Lines(ctx context.Context) <-chan string {
+	lineStream := make(chan string)
+	go func() {
+		defer close(lineStream)
+		for _, line := range w {
+			select {
+			case <-ctx.Done():
+				return
+			case lineStream <- line:
+			}
+		}
+	}()
+	return lineStream
+}
+
+func TestFSProxyCustomWatcher(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	watcher := sliceWatcher{rpcRequest(1, "first"), rpcRequest(2, "second")}
+	p := newTestProxy(t, server.URL, WithWatcher(watcher), WithOrderedOutput()).start()
+
+	// Run returns once the watcher closes the channel and lines are proxied
+	if err := p.wait(); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	want := []string{rpcResult(1, "first"), rpcResult(2, "second")}
+	if lines := splitLines(p.output()); !reflect.DeepEqual(lines, want) {
+		t.Errorf("output = %q, want %q", lines, want)
+	}
+}
+
+// fakeNotifier is notifier which reports events and errors sent by the test
+type fakeNotifier struct {
+	events chan fileEvent
+	errors chan error
+}
+
+func newFakeNotifier() *fakeNotifier {
+	return &fakeNotifier{events: make(chan fileEvent), errors: make(chan error)}
+}
+
+func (n *fakeNotifier) Events() <-chan fileEvent {
+	return n.events
+}
+
+func (n *fakeNotifier) Errors() <-chan error {
+	return n.errors
+}
+
+func (n *fakeNotifier) Close() error {
+	return nil
+}
+
+// useFakeNotifier replaces the notifier of the first input file of p, which must not be started yet
+func useFakeNotifier(t *testing.T, p *testProxy) *fakeNotifier {
+	t.Helper()
+	if err := p.inputs[0].notifier.Close(); err != nil {
+		t.Fatalf("close notifier: %v", err)
+	}
+	notifier := newFakeNotifier()
+	p.inputs[0].notifier = notifier
+	return notifier
+}
+
+func TestFSProxyWatcherErrors(t *testing.T) {
+	errWatch := errors.New("queue overflow")
+	tests := []struct {
+		name   string
+		notify func(n *fakeNotifier)
+		want   error
+	}{
+		{name: "error", notify: func(n *fakeNotifier) { n.errors <- errWatch }, want: errWatch},
+		{name: "events closed", notify: func(n *fakeNotifier) { close(n.events) }, want: ErrWatcherClosed},
+		{name: "errors closed", notify: func(n *fakeNotifier) { close(n.errors) }, want: ErrWatcherClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, "http://localhost")
+			notifier := useFakeNotifier(t, p)
+			p.start()
+
+			tt.notify(notifier)
+
+			if err := p.wait(); !errors.Is(err, tt.want) {
+				t.Errorf("run error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFSProxyErrorsDuringShutdown(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		p := newTestProxy(t, "http://localhost", WithExtraInputFiles(filepath.Join(t.TempDir(), "extra")))
+		var notifiers []*fakeNotifier
+		for _, in := range p.inputs {
+			if err := in.notifier.Close(); err != nil {
+				t.Fatalf("close notifier: %v", err)
+			}
+			notifier := newFakeNotifier()
+			in.notifier = notifier
+			notifiers = append(notifiers, notifier)
+		}
+		p.start()
+
+		// Both inputs fail while Run is cancelled, so errors are sent after Run has one already
+		stopped := make(chan struct{})
+		for _, notifier := range notifiers {
+			go func(n *fakeNotifier) {
+				select {
+				case n.errors <- errors.New("queue overflow"):
+				case <-stopped:
+				}
+			}(notifier)
+		}
+		p.cancel()
+
+		_ = p.wait()
+		close(stopped)
+	}
+}
+
+func TestFSProxyInputRemovedAndRecreated(t *testing.T) {
+	server := newRPCServer(t, echoHandler)
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{name: "fsnotify", opt: WithFSNotify()},
+		{name: "polling", opt: WithPolling(20 * time.Millisecond)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, server.URL, tt.opt).start()
+			p.write(rpcRequest(1, "before-removal"))
+			p.waitLines(1)
+
+			if err := os.Remove(p.inputPath); err != nil {
+				t.Fatalf("remove input: %v", err)
+			}
+			// The proxy keeps running while the input file is missing
+			select {
+			case err := <-p.done:
+				p.done = nil
+				t.Fatalf("run returned after removal: %v", err)
+			case <-time.After(100 * time.Millisecond):
+			}
+			p.write(rpcRequest(2, "after-recreation"))
+
+			if lines := p.waitLines(2); lines[1] != rpcResult(2, "after-recreation") {
+				t.Errorf("output = %q, want %q", lines[1], rpcResult(2, "after-recreation"))
+			}
+		})
+	}
+}
+
+func TestFSProxyReopenErrorWhileWaiting(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		wait func(p *testProxy)
+	}{
+		{
+			name: "waiting for lock",
+			opts: []Option{WithLockPollInterval(time.Hour)},
+			wait: func(p *testProxy) { appendFile(t, p.inputPath+".lock", "") },
+		},
+		{name: "debouncing writes", opts: []Option{WithWriteDebounce(time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProxy(t, "http://localhost", tt.opts...)
+			notifier := useFakeNotifier(t, p)
+			if tt.wait != nil {
+				tt.wait(p)
+			}
+			p.start()
+			notifier.events <- fileWritten
+
+			// The recreated input file is a symlink to itself, so opening it fails
+			if err := os.Remove(p.inputPath); err != nil {
+				t.Fatalf("remove input: %v", err)
+			}
+			if err := os.Symlink(filepath.Base(p.inputPath), p.inputPath); err != nil {
+				t.Skipf("symlink: %v", err)
+			}
+			notifier.events <- fileCreated
+
+			if err := p.wait(); err == nil || !strings.Contains(err.Error(), "open input file") {
+				t.Errorf("run error = %v, want error of reopening", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrConnectionClosed is returned by WebSocketSender for requests
+// whose response was not received before the connection closed
+var ErrConnectionClosed = errors.New("websocket connection closed")
+
+// ErrUnmatchedError is returned by WebSocketSender for requests in flight when an error response
+// with null id is received while several requests are in flight
+var ErrUnmatchedError = errors.New("error response with null id")
+
+// WebSocketSender sends requests to JSON-RPC server over a persistent WebSocket connection.
+// Responses are matched to requests by id. If the connection drops, requests waiting for
+// a response fail and the next request dials a new connection
+type WebSocketSender struct {
+	url    string
+	dialer *websocket.Dialer
+	header http.Header
+
+	mu   sync.Mutex
+	conn *wsConn
+}
+
+// NewWebSocketSender creates WebSocketSender. If dialer is nil, websocket.DefaultDialer is used
+func NewWebSocketSender(url string, dialer *websocket.Dialer, header http.Header) *WebSocketSender {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return &WebSocketSender{
+		url:    url,
+		dialer: dialer,
+		header: header,
+	}
+}
+
+// Send writes payload to the connection and waits for the response with the same id.
+// Notifications are only written, so nil response is returned for them. Requests with the same id
+// may be in flight at once, responses with it are passed to them in the order they are sent.
+// An error response with null id, e.g. to a request the server can not parse, is the response to
+// the only request in flight. If there are several ones, they all fail with ErrUnmatchedError,
+// as it is not known which one it replies to
+func (s *WebSocketSender) Send(ctx context.Context, payload []byte) ([]byte, error) {
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := correlationKey(payload)
+	if !ok {
+		if err := conn.write(ctx, payload); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	resultStream, err := conn.register(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.write(ctx, payload); err != nil {
+		conn.unregister(key, resultStream)
+		return nil, err
+	}
+	select {
+	case <-ctx.Done():
+		conn.unregister(key, resultStream)
+		return nil, ctx.Err()
+	case result := <-resultStream:
+		return result.response, result.err
+	}
+}
+
+// Close closes the current connection
+func (s *WebSocketSender) Close() error {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	if err := conn.conn.Close(); err != nil {
+		return fmt.Errorf("close connection: %w", err)
+	}
+	return nil
+}
+
+// connect returns the current connection or dials a new one if there is none
+func (s *WebSocketSender) connect(ctx context.Context) (*wsConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		return s.conn, nil
+	}
+	c, resp, err := s.dialer.DialContext(ctx, s.url, s.header)
+	if err != nil {
+		if resp != nil {
+			return nil, &StatusError{StatusCode: resp.StatusCode}
+		}
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	conn := &wsConn{
+		conn:    c,
+		pending: make(map[string][]chan wsResult),
+	}
+	s.conn = conn
+	go s.readResponses(conn)
+	return conn, nil
+}
+
+// readResponses passes responses to requests waiting for them until the connection is closed
+func (s *WebSocketSender) readResponses(conn *wsConn) {
+	for {
+		_, message, err := conn.conn.ReadMessage()
+		if err != nil {
+			s.mu.Lock()
+			if s.conn == conn {
+				s.conn = nil
+			}
+			s.mu.Unlock()
+			_ = conn.conn.Close()
+			conn.fail(fmt.Errorf("%w: %v", ErrConnectionClosed, err))
+			return
+		}
+		if key, ok := correlationKey(message); ok {
+			conn.resolve(key, message)
+		} else if isNullIDError(message) {
+			conn.resolveUnmatched(message)
+		}
+	}
+}
+
+// wsResult is a response or an error of a request sent over WebSocket
+type wsResult struct {
+	response []byte
+	err      error
+}
+
+// wsConn is a WebSocket connection with requests waiting for responses
+type wsConn struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string][]chan wsResult // requests waiting for responses by id in the order they are sent
+	err     error
+}
+
+func (c *wsConn) write(ctx context.Context, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return fmt.Errorf("set write deadline: %w", err)
+	}
+	if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+		// Closing makes readResponses fail waiting requests and drop the connection
+		_ = c.conn.Close()
+		return fmt.Errorf("write message: %w", err)
+	}
+	return nil
+}
+
+func (c *wsConn) register(key string) (chan wsResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	resultStream := make(chan wsResult, 1)
+	c.pending[key] = append(c.pending[key], resultStream)
+	return resultStream, nil
+}
+
+func (c *wsConn) unregister(key string, resultStream chan wsResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	streams := c.pending[key]
+	for i, stream := range streams {
+		if stream == resultStream {
+			streams = append(streams[:i:i], streams[i+1:]...)
+			break
+		}
+	}
+	if len(streams) == 0 {
+		delete(c.pending, key)
+		return
+	}
+	c.pending[key] = streams
+}
+
+// resolve passes response to the first request waiting for the response with key
+func (c *wsConn) resolve(key string, response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	streams, ok := c.pending[key]
+	if !ok {
+		return
+	}
+	streams[0] <- wsResult{response: response}
+	if len(streams) == 1 {
+		delete(c.pending, key)
+		return
+	}
+	c.pending[key] = streams[1:]
+}
+
+// resolveUnmatched passes the error response with null id to the request in flight
+// if it is the only one and fails all of them otherwise
+func (c *wsConn) resolveUnmatched(response []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inFlight := 0
+	for _, streams := range c.pending {
+		inFlight += len(streams)
+	}
+	result := wsResult{response: response}
+	if inFlight > 1 {
+		result = wsResult{err: fmt.Errorf("%w: %s", ErrUnmatchedError, response)}
+	}
+	for key, streams := range c.pending {
+		for _, stream := range streams {
+			stream <- result
+		}
+		delete(c.pending, key)
+	}
+}
+
+func (c *wsConn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+	for key, streams := range c.pending {
+		for _, stream := range streams {
+			stream <- wsResult{err: err}
+		}
+		delete(c.pending, key)
+	}
+}
+
+// isNullIDError reports whether message is an error response with null id
+func isNullIDError(message []byte) bool {
+	var response struct {
+		ID    json.RawMessage `json:"id"`
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(message, &response); err != nil {
+		return false
+	}
+	return string(response.ID) == "null" && len(response.Error) > 0 && string(response.Error) != "null"
+}
+
+// correlationKey returns the key matching a request to its response. It is the id
+// for a single message and sorted ids of all messages for a batch.
+// It returns false if no response is expected
+func correlationKey(payload []byte) (string, bool) {
+	if !isBatch(payload) {
+		id, ok := requestID(payload)
+		if !ok || string(id) == "null" {
+			return "", false
+		}
+		return string(id), true
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(payload, &items); err != nil {
+		return "", false
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		if id, ok := requestID(item); ok && string(id) != "null" {
+			ids = append(ids, string(id))
+		}
+	}
+	if len(ids) == 0 {
+		return "", false
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ","), true
+}
@@ -0,0 +1,180 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newWebSocketServer starts a test server which replies to every batch of n messages
+// with echoResponse in reverse order
+func newWebSocketServer(t *testing.T, n int) string {
+	t.Helper()
+	return newWebSocketServerFunc(t, func(conn *websocket.Conn) {
+		for {
+			messages := make([][]byte, 0, n)
+			for len(messages) < n {
+				_, message, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				messages = append(messages, message)
+			}
+			for i := len(messages) - 1; i >= 0; i-- {
+				response, err := echoResponse(messages[i])
+				if err != nil {
+					return
+				}
+				if err := conn.WriteMessage(websocket.TextMessage, response); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
+
+// newWebSocketServerFunc starts a test server which serves its connections with handle
+// and returns its URL
+func newWebSocketServerFunc(t *testing.T, handle func(conn *websocket.Conn)) string {
+	t.Helper()
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		handle(conn)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// sendAll sends payloads with sender at once and returns their responses and errors
+func sendAll(t *testing.T, sender *WebSocketSender, payloads ...string) ([]string, []error) {
+	t.Helper()
+	responses := make([]string, len(payloads))
+	errs := make([]error, len(payloads))
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, payload := range payloads {
+		wg.Add(1)
+		go func(i int, payload string) {
+			defer wg.Done()
+			response, err := sender.Send(context.Background(), []byte(payload))
+			responses[i], errs[i] = string(response), err
+		}(i, payload)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(waitTimeout):
+		t.Fatal("timed out waiting for responses")
+	}
+	return responses, errs
+}
+
+func TestWebSocketSenderMatchesResponsesByID(t *testing.T) {
+	sender := NewWebSocketSender(newWebSocketServer(t, 2), nil, nil)
+	defer sender.Close()
+
+	var wg sync.WaitGroup
+	for _, id := range []int{1, 2} {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			response, err := sender.Send(context.Background(), []byte(rpcRequest(id, "echo")))
+			if err != nil {
+				t.Errorf("send %d: %v", id, err)
+				return
+			}
+			if string(response) != rpcResult(id, "echo") {
+				t.Errorf("response to %d = %s, want %s", id, response, rpcResult(id, "echo"))
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+func TestFSProxyWebSocketSender(t *testing.T) {
+	sender := NewWebSocketSender(newWebSocketServer(t, 1), nil, nil)
+	defer sender.Close()
+	p := newTestProxy(t, "", WithSender(sender)).start()
+
+	p.write(rpcRequest(1, "ping"))
+
+	if lines := p.waitLines(1); lines[0] != rpcResult(1, "ping") {
+		t.Errorf("output = %q, want %q", lines[0], rpcResult(1, "ping"))
+	}
+}
+
+func TestWebSocketSenderSameID(t *testing.T) {
+	sender := NewWebSocketSender(newWebSocketServer(t, 1), nil, nil)
+	defer sender.Close()
+
+	responses, errs := sendAll(t, sender, rpcRequest(1, "first"), rpcRequest(1, "second"))
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+	}
+	// Responses with the same id can not be told apart, so it is only checked both are received
+	sort.Strings(responses)
+	if want := []string{rpcResult(1, "first"), rpcResult(1, "second")}; !reflect.DeepEqual(responses, want) {
+		t.Errorf("responses = %q, want %q", responses, want)
+	}
+}
+
+func TestWebSocketSenderNullIDError(t *testing.T) {
+	const parseError = `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"Parse error"}}`
+	tests := []struct {
+		name     string
+		payloads []string
+		wantErr  bool
+	}{
+		// The error is the response to the only request in flight
+		{name: "single request", payloads: []string{rpcRequest(1, "ping")}},
+		// It is not known which request the error replies to, so all of them fail
+		{name: "several requests", payloads: []string{rpcRequest(1, "ping"), rpcRequest(2, "ping")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := len(tt.payloads)
+			url := newWebSocketServerFunc(t, func(conn *websocket.Conn) {
+				for i := 0; i < n; i++ {
+					if _, _, err := conn.ReadMessage(); err != nil {
+						return
+					}
+				}
+				_ = conn.WriteMessage(websocket.TextMessage, []byte(parseError))
+				_, _, _ = conn.ReadMessage()
+			})
+			sender := NewWebSocketSender(url, nil, nil)
+			defer sender.Close()
+
+			responses, errs := sendAll(t, sender, tt.payloads...)
+
+			for i := range tt.payloads {
+				switch {
+				case tt.wantErr && !errors.Is(errs[i], ErrUnmatchedError):
+					t.Errorf("error of request %d = %v, want %v", i, errs[i], ErrUnmatchedError)
+				case !tt.wantErr && (errs[i] != nil || responses[i] != parseError):
+					t.Errorf("response to request %d = %q, %v, want %q", i, responses[i], errs[i], parseError)
+				}
+			}
+		})
+	}
+}